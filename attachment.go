@@ -0,0 +1,161 @@
+package tinymail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dispositionAttachment = "attachment"
+	dispositionInline     = "inline"
+)
+
+// Attachment is a file attached to or embedded into a message.
+type Attachment struct {
+	// open returns the content of the file. It is called
+	// every time the message is written, so the content is
+	// streamed instead of being held in memory.
+	open        func() (io.ReadCloser, error)
+	filename    string
+	contentType string
+	disposition string
+	// contentID is set for files embedded into the HTML body.
+	contentID string
+}
+
+// commonContentTypes are the content types of common attachments which
+// take precedence over [mime.TypeByExtension], as the system MIME type
+// tables often miss or disagree on them.
+var commonContentTypes = map[string]string{
+	".csv":  "text/csv; charset=utf-8",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".ics":  "text/calendar; charset=utf-8",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".txt":  "text/plain; charset=utf-8",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".zip":  "application/zip",
+}
+
+// fileAttachment returns an attachment reading file when the message is written.
+//
+// Returns an error if file does not exist or is a directory.
+func fileAttachment(file string, contentType string) (*Attachment, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", file)
+	}
+	return &Attachment{
+		open: func() (io.ReadCloser, error) {
+			return os.Open(file)
+		},
+		contentType: contentType,
+	}, nil
+}
+
+// fsAttachment returns an attachment reading file from fsys when the message is written.
+//
+// Returns an error if file does not exist or is a directory.
+func fsAttachment(fsys fs.FS, file string, contentType string) (*Attachment, error) {
+	info, err := fs.Stat(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", file)
+	}
+	return &Attachment{
+		open: func() (io.ReadCloser, error) {
+			return fsys.Open(file)
+		},
+		contentType: contentType,
+	}, nil
+}
+
+// readerAttachment returns an attachment reading r when the message is written.
+//
+// r can only be read once, writing the message again returns an error.
+func readerAttachment(name string, r io.Reader, contentType string) *Attachment {
+	read := false
+	return &Attachment{
+		open: func() (io.ReadCloser, error) {
+			if read {
+				return nil, fmt.Errorf("attachment %s has already been read", name)
+			}
+			read = true
+			return io.NopCloser(r), nil
+		},
+		contentType: contentType,
+	}
+}
+
+// bytesAttachment returns an attachment with content data.
+func bytesAttachment(data []byte, contentType string) *Attachment {
+	return &Attachment{
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+		contentType: contentType,
+	}
+}
+
+// Filename returns the file name of the attachment.
+func (a *Attachment) Filename() string {
+	return a.filename
+}
+
+// ContentType returns the content type of the attachment.
+func (a *Attachment) ContentType() string {
+	return a.contentType
+}
+
+// Disposition returns "attachment" for attached and "inline" for embedded files.
+func (a *Attachment) Disposition() string {
+	return a.disposition
+}
+
+// ContentID returns the content ID of an embedded file.
+func (a *Attachment) ContentID() string {
+	return a.contentID
+}
+
+// contentIDDomain is the domain of content IDs, which RFC 2392 requires
+// to have the form of an address.
+const contentIDDomain = "tinymail"
+
+// ContentID returns the content ID [message.Embed] uses for a file name,
+// e.g. "logo.png@tinymail" for "logo.png".
+//
+// Characters other than ASCII letters, digits, '.', '-' and '_'
+// of the file name are replaced by '_'.
+func ContentID(fileName string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9',
+			r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, fileName) + "@" + contentIDDomain
+}
+
+// contentTypeByName returns the content type for the extension of name,
+// application/octet-stream if it is unknown.
+func contentTypeByName(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if contentType, ok := commonContentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
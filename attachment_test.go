@@ -0,0 +1,81 @@
+package tinymail
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readAttachments returns the file names and contents of attachments
+// in their order as "name: content".
+func readAttachments(t *testing.T, attachments ...*Attachment) []string {
+	contents := []string{}
+	for _, a := range attachments {
+		r, err := a.open()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		contents = append(contents, a.filename+": "+string(content))
+	}
+	return contents
+}
+
+func TestFileAttachment(t *testing.T) {
+	assert := assert.New(t)
+	fileName := "test_file_attachment"
+	assert.NoError(os.WriteFile(fileName, []byte("before"), 0644))
+	defer os.Remove(fileName)
+
+	a, err := fileAttachment(fileName, "text/plain")
+	assert.NoError(err)
+	assert.Equal("text/plain", a.ContentType())
+	assert.NoError(os.WriteFile(fileName, []byte("after"), 0644))
+	assert.Equal([]string{": after"}, readAttachments(t, a))
+
+	_, err = fileAttachment("missing", "text/plain")
+	assert.Error(err)
+	_, err = fileAttachment(".", "text/plain")
+	assert.Error(err)
+}
+
+func TestFSAttachment(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a")}}
+
+	a, err := fsAttachment(fsys, "a.txt", "text/plain")
+	assert.NoError(err)
+	assert.Equal([]string{": a"}, readAttachments(t, a))
+
+	_, err = fsAttachment(fsys, "b.txt", "text/plain")
+	assert.Error(err)
+}
+
+func TestReaderAttachment(t *testing.T) {
+	assert := assert.New(t)
+
+	a := readerAttachment("a.txt", strings.NewReader("a"), "text/plain")
+	assert.Equal([]string{": a"}, readAttachments(t, a))
+	_, err := a.open()
+	assert.ErrorContains(err, "a.txt")
+}
+
+func TestContentTypeByName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("application/pdf", contentTypeByName("a/report.PDF"))
+	assert.Equal("text/csv; charset=utf-8", contentTypeByName("export.csv"))
+	assert.Equal("application/octet-stream", contentTypeByName("no_extension"))
+}
+
+func TestContentID(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("logo.png@tinymail", ContentID("logo.png"))
+	assert.Equal("Gr__e_logo-1.png@tinymail", ContentID("Größe logo-1.png"))
+	assert.Equal("a_b.png@tinymail", ContentID("a@b.png"))
+}
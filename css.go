@@ -0,0 +1,686 @@
+package tinymail
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// rawTextElements contain text which is not parsed as HTML.
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+// voidElements have no closing tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// unstyledElements are not rendered and never get inline styles.
+var unstyledElements = map[string]bool{
+	"base": true, "head": true, "link": true, "meta": true,
+	"script": true, "style": true, "title": true,
+}
+
+// element is a start tag of an HTML document.
+type element struct {
+	name    string
+	id      string
+	classes []string
+	// parent is the index of the parent element or -1.
+	parent int
+	// style is the value of the style attribute, styleStart and styleEnd
+	// the offsets of the attribute if it is present.
+	style      string
+	styleStart int
+	styleEnd   int
+	// close is the offset of the ">" or "/>" ending the start tag.
+	close int
+}
+
+// styleElement is a <style> element of an HTML document.
+type styleElement struct {
+	// start and end are the offsets of the element, contentStart
+	// and contentEnd the offsets of its CSS.
+	start, end, contentStart, contentEnd int
+	inline                               bool
+}
+
+// cssRule is a rule of a style sheet with the selectors which can be inlined.
+type cssRule struct {
+	selectors    []*cssSelector
+	declarations []cssDeclaration
+}
+
+// cssSelector is a complex selector of compound selectors
+// from right to left with the combinators between them.
+type cssSelector struct {
+	compounds   []cssCompound
+	combinators []byte
+	specificity int
+}
+
+// cssCompound is a compound selector like div.note#first.
+type cssCompound struct {
+	name    string
+	id      string
+	classes []string
+}
+
+type cssDeclaration struct {
+	property  string
+	value     string
+	important bool
+}
+
+// edit replaces the document between start and end with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+// InlineCSS moves the rules of the <style> elements of an HTML document into
+// the style attributes of the elements they apply to, as many email clients
+// ignore style sheets. The style attributes take precedence over the rules,
+// declarations marked !important over both, with those of the style attributes
+// taking precedence again. The !important marker is kept.
+//
+// Selectors of element names, classes, IDs and * combined as descendants or
+// children like "table.body td > p" are inlined. All other rules, like @media
+// rules or rules with pseudo-classes, are kept in the <style> element, which
+// is removed if empty. <style> elements with a media attribute other than
+// "all" or "screen" are not inlined.
+//
+// Returns an error if a <style> element or one of its rules is not terminated.
+func InlineCSS(document string) (string, error) {
+	elements, styles, err := parseHTML(document)
+	if err != nil {
+		return "", err
+	}
+
+	var rules []cssRule
+	var edits []edit
+	for _, style := range styles {
+		if !style.inline {
+			continue
+		}
+		styleRules, remaining, err := parseCSS(document[style.contentStart:style.contentEnd])
+		if err != nil {
+			return "", err
+		}
+		rules = append(rules, styleRules...)
+		if strings.TrimSpace(remaining) == "" {
+			edits = append(edits, edit{start: style.start, end: style.end})
+		} else {
+			edits = append(edits, edit{start: style.contentStart, end: style.contentEnd, text: "\n" + remaining + "\n"})
+		}
+	}
+	if len(rules) == 0 {
+		return document, nil
+	}
+
+	for i, el := range elements {
+		if unstyledElements[el.name] {
+			continue
+		}
+		declarations := matchingDeclarations(elements, i, rules)
+		if len(declarations) == 0 {
+			continue
+		}
+		style := html.EscapeString(formatDeclarations(declarations))
+		if el.styleEnd > 0 {
+			edits = append(edits, edit{start: el.styleStart, end: el.styleEnd, text: `style="` + style + `"`})
+		} else {
+			edits = append(edits, edit{start: el.close, end: el.close, text: ` style="` + style + `"`})
+		}
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var b strings.Builder
+	offset := 0
+	for _, e := range edits {
+		b.WriteString(document[offset:e.start])
+		b.WriteString(e.text)
+		offset = e.end
+	}
+	b.WriteString(document[offset:])
+	return b.String(), nil
+}
+
+// matchingDeclarations returns the declarations of rules and the style attribute
+// applying to element i ordered by precedence, so later ones win.
+func matchingDeclarations(elements []element, i int, rules []cssRule) []cssDeclaration {
+	type match struct {
+		specificity  int
+		declarations []cssDeclaration
+	}
+	var matches []match
+	for _, rule := range rules {
+		specificity := -1
+		for _, selector := range rule.selectors {
+			if selector.specificity > specificity && selector.matches(elements, i) {
+				specificity = selector.specificity
+			}
+		}
+		if specificity >= 0 {
+			matches = append(matches, match{specificity, rule.declarations})
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].specificity < matches[b].specificity })
+
+	var normal, important []cssDeclaration
+	for _, m := range matches {
+		for _, d := range m.declarations {
+			if d.important {
+				important = append(important, d)
+			} else {
+				normal = append(normal, d)
+			}
+		}
+	}
+	var styleImportant []cssDeclaration
+	for _, d := range parseDeclarations(elements[i].style) {
+		if d.important {
+			styleImportant = append(styleImportant, d)
+		} else {
+			normal = append(normal, d)
+		}
+	}
+	important = append(important, styleImportant...)
+	return append(normal, important...)
+}
+
+// formatDeclarations formats declarations as style attribute value.
+// Repeated properties keep their first position with the last value.
+func formatDeclarations(declarations []cssDeclaration) string {
+	var properties []string
+	values := map[string]cssDeclaration{}
+	for _, d := range declarations {
+		if _, ok := values[d.property]; !ok {
+			properties = append(properties, d.property)
+		}
+		values[d.property] = d
+	}
+	formatted := make([]string, len(properties))
+	for i, property := range properties {
+		formatted[i] = property + ": " + values[property].value
+		if values[property].important {
+			formatted[i] += " !important"
+		}
+	}
+	return strings.Join(formatted, "; ")
+}
+
+// matches reports whether s matches element i.
+func (s *cssSelector) matches(elements []element, i int) bool {
+	return s.matchesFrom(elements, i, 0)
+}
+
+// matchesFrom reports whether the compounds of s starting at c match element i
+// and its ancestors.
+func (s *cssSelector) matchesFrom(elements []element, i int, c int) bool {
+	if !s.compounds[c].matches(&elements[i]) {
+		return false
+	}
+	if c == len(s.compounds)-1 {
+		return true
+	}
+	for parent := elements[i].parent; parent >= 0; parent = elements[parent].parent {
+		if s.matchesFrom(elements, parent, c+1) {
+			return true
+		}
+		if s.combinators[c] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+// matches reports whether c matches el.
+func (c *cssCompound) matches(el *element) bool {
+	if c.name != "" && c.name != "*" && c.name != el.name {
+		return false
+	}
+	if c.id != "" && c.id != el.id {
+		return false
+	}
+	for _, class := range c.classes {
+		found := false
+		for _, elClass := range el.classes {
+			if class == elClass {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseHTML returns the start tags and <style> elements of document.
+//
+// Returns an error if a <style> element is not terminated.
+func parseHTML(document string) ([]element, []styleElement, error) {
+	var elements []element
+	var styles []styleElement
+	var open []int
+	for i := 0; i < len(document); {
+		if document[i] != '<' {
+			i++
+			continue
+		}
+		switch {
+		case strings.HasPrefix(document[i:], "<!--"):
+			end := strings.Index(document[i+4:], "-->")
+			if end < 0 {
+				return elements, styles, nil
+			}
+			i += 4 + end + 3
+		case strings.HasPrefix(document[i:], "<!") || strings.HasPrefix(document[i:], "<?"):
+			end := strings.IndexByte(document[i:], '>')
+			if end < 0 {
+				return elements, styles, nil
+			}
+			i += end + 1
+		case strings.HasPrefix(document[i:], "</"):
+			end := strings.IndexByte(document[i:], '>')
+			if end < 0 {
+				return elements, styles, nil
+			}
+			name := strings.ToLower(strings.TrimSpace(document[i+2 : i+end]))
+			for j := len(open) - 1; j >= 0; j-- {
+				if elements[open[j]].name == name {
+					open = open[:j]
+					break
+				}
+			}
+			i += end + 1
+		case i+1 < len(document) && isASCIILetter(document[i+1]):
+			parent := -1
+			if len(open) > 0 {
+				parent = open[len(open)-1]
+			}
+			el, attributes, end, selfClosing := parseStartTag(document, i)
+			el.parent = parent
+			elements = append(elements, el)
+			start := i
+			i = end
+			if rawTextElements[el.name] {
+				closeTag := indexCloseTag(document[i:], el.name)
+				if closeTag < 0 {
+					if el.name == "style" {
+						return nil, nil, fmt.Errorf("style element at offset %d is not terminated", start)
+					}
+					return elements, styles, nil
+				}
+				contentEnd := i + closeTag
+				closeEnd := strings.IndexByte(document[contentEnd:], '>')
+				if closeEnd < 0 {
+					closeEnd = len(document) - contentEnd - 1
+				}
+				if el.name == "style" {
+					media := strings.ToLower(strings.TrimSpace(attributes["media"]))
+					styles = append(styles, styleElement{
+						start:        start,
+						end:          contentEnd + closeEnd + 1,
+						contentStart: i,
+						contentEnd:   contentEnd,
+						inline:       media == "" || media == "all" || media == "screen",
+					})
+				}
+				i = contentEnd + closeEnd + 1
+				continue
+			}
+			if !selfClosing && !voidElements[el.name] {
+				open = append(open, len(elements)-1)
+			}
+		default:
+			i++
+		}
+	}
+	return elements, styles, nil
+}
+
+// indexCloseTag returns the index of the first closing tag of the element
+// name in s, matching the name case-insensitively, or -1 if there is none.
+func indexCloseTag(s, name string) int {
+	for i := 0; ; {
+		j := strings.Index(s[i:], "</")
+		if j < 0 {
+			return -1
+		}
+		i += j + 2
+		if len(s)-i >= len(name) && strings.EqualFold(s[i:i+len(name)], name) {
+			return i - 2
+		}
+	}
+}
+
+// parseStartTag parses the start tag at offset start of document.
+//
+// Returns the element, its attributes, the offset after the tag and
+// whether it is self-closing.
+func parseStartTag(document string, start int) (element, map[string]string, int, bool) {
+	el := element{}
+	attributes := map[string]string{}
+	i := start + 1
+	for i < len(document) && !isHTMLSpace(document[i]) && document[i] != '>' && document[i] != '/' {
+		i++
+	}
+	el.name = strings.ToLower(document[start+1 : i])
+	for i < len(document) {
+		for i < len(document) && isHTMLSpace(document[i]) {
+			i++
+		}
+		if i >= len(document) {
+			break
+		}
+		if document[i] == '>' {
+			el.close = i
+			return el, attributes, i + 1, false
+		}
+		if strings.HasPrefix(document[i:], "/>") {
+			el.close = i
+			return el, attributes, i + 2, true
+		}
+		if document[i] == '/' {
+			i++
+			continue
+		}
+		attrStart := i
+		for i < len(document) && !isHTMLSpace(document[i]) && document[i] != '=' && document[i] != '>' && !strings.HasPrefix(document[i:], "/>") {
+			i++
+		}
+		name := strings.ToLower(document[attrStart:i])
+		value := ""
+		j := i
+		for j < len(document) && isHTMLSpace(document[j]) {
+			j++
+		}
+		if j < len(document) && document[j] == '=' {
+			j++
+			for j < len(document) && isHTMLSpace(document[j]) {
+				j++
+			}
+			if j < len(document) && (document[j] == '"' || document[j] == '\'') {
+				end := strings.IndexByte(document[j+1:], document[j])
+				if end < 0 {
+					end = len(document) - j - 1
+					value = document[j+1:]
+					i = len(document)
+				} else {
+					value = document[j+1 : j+1+end]
+					i = j + 1 + end + 1
+				}
+			} else {
+				valueStart := j
+				for j < len(document) && !isHTMLSpace(document[j]) && document[j] != '>' {
+					j++
+				}
+				value = document[valueStart:j]
+				i = j
+			}
+		}
+		value = html.UnescapeString(value)
+		if _, ok := attributes[name]; ok {
+			continue
+		}
+		attributes[name] = value
+		switch name {
+		case "id":
+			el.id = value
+		case "class":
+			el.classes = strings.Fields(value)
+		case "style":
+			el.style = value
+			el.styleStart = attrStart
+			el.styleEnd = i
+		}
+	}
+	el.close = len(document)
+	return el, attributes, len(document), false
+}
+
+// parseCSS returns the rules of css which can be inlined and the
+// remaining rules, one per line. Comments are removed.
+//
+// Returns an error if a rule or comment is not terminated.
+func parseCSS(css string) ([]cssRule, string, error) {
+	var rules []cssRule
+	var remaining []string
+	i := 0
+	for i < len(css) {
+		switch {
+		case isHTMLSpace(css[i]):
+			i++
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return nil, "", fmt.Errorf("css comment is not terminated")
+			}
+			i += 2 + end + 2
+		case strings.HasPrefix(css[i:], "<!--"):
+			i += 4
+		case strings.HasPrefix(css[i:], "-->"):
+			i += 3
+		case css[i] == '@':
+			end := indexTopLevel(css, i, ';', '{')
+			if end < 0 {
+				return nil, "", fmt.Errorf("css at-rule is not terminated")
+			}
+			if css[end] == '{' {
+				end = matchingBrace(css, end)
+				if end < 0 {
+					return nil, "", fmt.Errorf("css at-rule is not terminated")
+				}
+			}
+			remaining = append(remaining, css[i:end+1])
+			i = end + 1
+		default:
+			open := indexTopLevel(css, i, '{', '{')
+			if open < 0 {
+				return nil, "", fmt.Errorf("css rule %q is not terminated", strings.TrimSpace(css[i:]))
+			}
+			end := matchingBrace(css, open)
+			if end < 0 {
+				return nil, "", fmt.Errorf("css rule %q is not terminated", strings.TrimSpace(css[i:open]))
+			}
+			body := css[open+1 : end]
+			var kept []string
+			rule := cssRule{declarations: parseDeclarations(body)}
+			for _, group := range splitTopLevel(css[i:open], ',') {
+				selector := parseSelector(group)
+				if selector == nil {
+					kept = append(kept, strings.TrimSpace(group))
+					continue
+				}
+				rule.selectors = append(rule.selectors, selector)
+			}
+			if len(rule.selectors) > 0 {
+				rules = append(rules, rule)
+			}
+			if len(kept) > 0 {
+				remaining = append(remaining, strings.Join(kept, ", ")+" {"+body+"}")
+			}
+			i = end + 1
+		}
+	}
+	return rules, strings.Join(remaining, "\n"), nil
+}
+
+// parseSelector parses a complex selector like "table.body td > p".
+//
+// Returns nil if the selector is empty or uses unsupported features
+// like attributes, pseudo-classes or sibling combinators.
+func parseSelector(s string) *cssSelector {
+	selector := &cssSelector{}
+	combinator := byte(' ')
+	for _, token := range strings.Fields(strings.ReplaceAll(s, ">", " > ")) {
+		if token == ">" {
+			if len(selector.compounds) == 0 || combinator == '>' {
+				return nil
+			}
+			combinator = '>'
+			continue
+		}
+		compound, specificity, ok := parseCompound(token)
+		if !ok {
+			return nil
+		}
+		if len(selector.compounds) > 0 {
+			selector.combinators = append([]byte{combinator}, selector.combinators...)
+		}
+		selector.compounds = append([]cssCompound{compound}, selector.compounds...)
+		selector.specificity += specificity
+		combinator = ' '
+	}
+	if len(selector.compounds) == 0 || combinator == '>' {
+		return nil
+	}
+	return selector
+}
+
+// parseCompound parses a compound selector like "td.note#first" and returns
+// its specificity, weighting IDs over classes over element names.
+func parseCompound(s string) (cssCompound, int, bool) {
+	compound := cssCompound{}
+	specificity := 0
+	i := 0
+	for i < len(s) && isCSSName(s[i]) {
+		i++
+	}
+	compound.name = strings.ToLower(s[:i])
+	if strings.HasPrefix(s, "*") {
+		i = 1
+	} else if compound.name != "" {
+		specificity++
+	}
+	for i < len(s) {
+		kind := s[i]
+		if kind != '.' && kind != '#' {
+			return compound, 0, false
+		}
+		i++
+		start := i
+		for i < len(s) && isCSSName(s[i]) {
+			i++
+		}
+		if start == i {
+			return compound, 0, false
+		}
+		if kind == '#' {
+			compound.id = s[start:i]
+			specificity += 10000
+		} else {
+			compound.classes = append(compound.classes, s[start:i])
+			specificity += 100
+		}
+	}
+	return compound, specificity, true
+}
+
+// parseDeclarations parses declarations like "color: red; margin: 0 !important".
+func parseDeclarations(s string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range splitTopLevel(s, ';') {
+		property, value, found := strings.Cut(declaration, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !found || property == "" || value == "" {
+			continue
+		}
+		important := false
+		if i := strings.LastIndex(value, "!"); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:i])
+		}
+		declarations = append(declarations, cssDeclaration{property, value, important})
+	}
+	return declarations
+}
+
+// splitTopLevel splits s at sep outside of quotes and parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for {
+		i := indexTopLevel(s, start, sep, sep)
+		if i < 0 {
+			return append(parts, s[start:])
+		}
+		parts = append(parts, s[start:i])
+		start = i + 1
+	}
+}
+
+// indexTopLevel returns the index of the first a or b in s from start
+// outside of quotes and parentheses, or -1.
+func indexTopLevel(s string, start int, a byte, b byte) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0 && (c == a || c == b):
+			return i
+		}
+	}
+	return -1
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or -1.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i >= 0 && i < len(s); {
+		i = indexTopLevel(s, i, '{', '}')
+		if i < 0 {
+			return -1
+		}
+		if s[i] == '{' {
+			depth++
+		} else {
+			depth--
+		}
+		if depth == 0 {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// isCSSName reports whether c may be part of a CSS identifier.
+func isCSSName(c byte) bool {
+	return isASCIILetter(c) || c >= '0' && c <= '9' || c == '-' || c == '_' || c >= 0x80
+}
@@ -0,0 +1,131 @@
+package tinymail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInlineCSS(t *testing.T) {
+	test := assert.New(t)
+
+	document := `<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Test</title>
+    <style>
+      /* base styles */
+      body { background-color: #f6f6f6; font-family: sans-serif }
+      p, td { margin: 0 }
+      .note { color: red; font-size: 12px }
+      p.note { color: blue }
+      #first { color: green }
+      table.body td > p { padding: 4px }
+      a:hover { color: black }
+      @media only screen and (max-width: 620px) { p { font-size: 16px } }
+    </style>
+  </head>
+  <body>
+    <table class="body"><tr><td><p id="first" class="note">first</p></td></tr></table>
+    <p class="note" style="font-size: 14px">second</p>
+    <div><span class="note">third</span><br></div>
+    <a href="#">link</a>
+  </body>
+</html>`
+
+	want := `<!doctype html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Test</title>
+    <style>
+a:hover { color: black }
+@media only screen and (max-width: 620px) { p { font-size: 16px } }
+</style>
+  </head>
+  <body style="background-color: #f6f6f6; font-family: sans-serif">
+    <table class="body"><tr><td style="margin: 0"><p id="first" class="note" style="margin: 0; color: green; font-size: 12px; padding: 4px">first</p></td></tr></table>
+    <p class="note" style="margin: 0; color: blue; font-size: 14px">second</p>
+    <div><span class="note" style="color: red; font-size: 12px">third</span><br></div>
+    <a href="#">link</a>
+  </body>
+</html>`
+
+	inlined, err := InlineCSS(document)
+	test.NoError(err)
+	test.Equal(want, inlined)
+}
+
+func TestInlineCSSPrecedence(t *testing.T) {
+	test := assert.New(t)
+
+	for document, want := range map[string]string{
+		`<style>p { color: red !important } p.a { color: blue }</style><p class="a" style="color: green">x</p>`: `<p class="a" style="color: red !important">x</p>`,
+		`<style>.a.b { color: red } .a { color: blue }</style><p class="b a">x</p>`:                             `<p class="b a" style="color: red">x</p>`,
+		`<style>* { margin: 0 } P { margin: 1px }</style><P>x</P><img src="a.png"/>`:                            `<P style="margin: 1px">x</P><img src="a.png" style="margin: 0"/>`,
+		`<style>p { font-family: "A; B", serif; background: url("a.png") }</style><p>x</p>`:                     `<p style="font-family: &#34;A; B&#34;, serif; background: url(&#34;a.png&#34;)">x</p>`,
+		`<style>div > p { color: red }</style><div><span><p>x</p></span></div>`:                                 `<div><span><p>x</p></span></div>`,
+		`<style>div p { color: red }</style><div><span><p>x</p></span></div>`:                                   `<div><span><p style="color: red">x</p></span></div>`,
+		`<style>p ~ p, p + p, [title] { color: red }</style><p>x</p>`:                                           `<style>p ~ p, p + p, [title] { color: red }</style><p>x</p>`,
+		`<style media="print">p { color: red }</style><p>x</p>`:                                                 `<style media="print">p { color: red }</style><p>x</p>`,
+		`<STYLE>p { color: red }</STYLE><!-- <p> --><p title="a > b" >x</p>`:                                    `<!-- <p> --><p title="a > b"  style="color: red">x</p>`,
+		`<style>td { padding: 0 }</style><table><tr><td><p style=color:blue>x</td></tr></table>`:                `<table><tr><td style="padding: 0"><p style=color:blue>x</td></tr></table>`,
+		`<style>p{color:red !important}</style><p style="color: blue !important">x</p>`:                         `<p style="color: blue !important">x</p>`,
+		`<style>p{color:red !important}</style><p style="color: blue">x</p>`:                                    `<p style="color: red !important">x</p>`,
+		`<p style='color: red'>no style</p>`:                                                                    `<p style='color: red'>no style</p>`,
+	} {
+		inlined, err := InlineCSS(document)
+		test.NoError(err, document)
+		test.Equal(want, inlined, document)
+	}
+}
+
+func TestInlineCSSErrors(t *testing.T) {
+	test := assert.New(t)
+
+	for _, document := range []string{
+		`<style>p { color: red }`,
+		`<style>p { color: red </style><p>x</p>`,
+		`<style>/* comment </style><p>x</p>`,
+		`<style>@media screen { p { color: red } </style><p>x</p>`,
+	} {
+		_, err := InlineCSS(document)
+		test.Error(err, document)
+	}
+}
+
+func TestInlineCSSNonASCII(t *testing.T) {
+	test := assert.New(t)
+
+	for document, want := range map[string]string{
+		"<p>ẞİẞİ</p><style>p { color: red }</style><p>x</p>":                 `<p style="color: red">ẞİẞİ</p><p style="color: red">x</p>`,
+		"<p>\xff\xfe</p><STYLE>p { color: red }</Style><p>x</p>":             "<p style=\"color: red\">\xff\xfe</p><p style=\"color: red\">x</p>",
+		"<title>İİİİ</TİTLE></title><style>p { color: red }</style><p>x</p>": `<title>İİİİ</TİTLE></title><p style="color: red">x</p>`,
+	} {
+		inlined, err := InlineCSS(document)
+		test.NoError(err, document)
+		test.Equal(want, inlined, document)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	test := assert.New(t)
+
+	for selector, specificity := range map[string]int{
+		"p":                 1,
+		"*":                 0,
+		".note":             100,
+		"p.note.small":      201,
+		"#first":            10000,
+		"table.body td > p": 103,
+	} {
+		parsed := parseSelector(selector)
+		if test.NotNil(parsed, selector) {
+			test.Equal(specificity, parsed.specificity, selector)
+		}
+	}
+	for _, selector := range []string{"", "> p", "p >", "p > > a", "a:hover", "p::before", "[title]", "p + p", "p.", "#"} {
+		test.Nil(parseSelector(selector), selector)
+	}
+}
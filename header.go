@@ -0,0 +1,207 @@
+package tinymail
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHeaderLineLength is the recommended line length of RFC5322 2.1.1.
+const maxHeaderLineLength = 78
+
+// maxLineLength is the line length limit of RFC5322 2.1.1 without CRLF.
+const maxLineLength = 998
+
+var newlineReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// quotedStringReplacer escapes the characters of an RFC5322 quoted-string.
+var quotedStringReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// reservedHeaders are written from the message fields and
+// the MIME structure and cannot be set as custom headers.
+var reservedHeaders = map[string]bool{
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Id":                true,
+	"Date":                      true,
+	"Message-Id":                true,
+	"In-Reply-To":               true,
+	"References":                true,
+	"From":                      true,
+	"Sender":                    true,
+	"Reply-To":                  true,
+	"Return-Path":               true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Subject":                   true,
+	"Priority":                  true,
+}
+
+// foldHeader returns the header field name: value terminated by CRLF.
+//
+// Line breaks in value are replaced by spaces and lines longer than
+// 78 characters are folded at whitespace as described in RFC5322 2.2.3.
+// Words longer than a line are not split, see [validateHeader].
+func foldHeader(name, value string) string {
+	var b strings.Builder
+	line := name + ":"
+	for _, word := range headerWords(" " + newlineReplacer.Replace(value)) {
+		if len(line)+len(word) > maxHeaderLineLength && line != name+":" {
+			b.WriteString(line)
+			b.WriteString("\r\n")
+			line = word
+			continue
+		}
+		line += word
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// headerWords splits s before every run of whitespace so that each word
+// keeps its leading whitespace.
+func headerWords(s string) []string {
+	var words []string
+	start := 0
+	for i := 1; i < len(s); i++ {
+		if isWSP(s[i]) && !isWSP(s[i-1]) {
+			words = append(words, s[start:i])
+			start = i
+		}
+	}
+	return append(words, s[start:])
+}
+
+// isWSP reports whether c is a space or horizontal tab.
+func isWSP(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// encodeHeader encodes value as RFC2047 encoded words if it contains
+// non-ASCII characters. Mostly ASCII values are Q encoded to stay readable,
+// all others are B encoded.
+func encodeHeader(value string) string {
+	nonASCII := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] >= utf8.RuneSelf {
+			nonASCII++
+		}
+	}
+	if nonASCII == 0 {
+		return value
+	}
+	if nonASCII > len(value)/2 {
+		return mime.BEncoding.Encode("utf-8", value)
+	}
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// withFilename adds the parameter key with filename to the media type
+// or disposition value, quoted if needed. Non-ASCII file names are RFC2231
+// encoded, preceded by an RFC2047 encoded fallback for clients without
+// RFC2231 support. Quotes and backslashes are escaped in the fallback, as Q
+// encoding keeps them.
+//
+// Returns value unchanged if it cannot be parsed.
+func withFilename(value string, key string, filename string) string {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return value
+	}
+	params[key] = filename
+	formatted := mime.FormatMediaType(mediaType, params)
+	if formatted == "" {
+		return value
+	}
+	if encoded := encodeHeader(filename); encoded != filename {
+		formatted = fmt.Sprintf(`%s; %s="%s"%s`, mediaType, key, quotedStringReplacer.Replace(encoded), strings.TrimPrefix(formatted, mediaType))
+	}
+	return formatted
+}
+
+// formatAddressList formats addresses for an address header.
+func formatAddressList(addresses []*mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = formatAddress(address)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// formatAddress formats address for an address header.
+//
+// Display names are quoted and RFC2047 encoded if needed.
+func formatAddress(address *mail.Address) string {
+	if address.Name == "" {
+		return address.Address
+	}
+	return address.String()
+}
+
+// parseMessageID returns id enclosed in angle brackets.
+//
+// Returns an error if id is not of the form left@right without
+// whitespace or angle brackets.
+func parseMessageID(id string) (string, error) {
+	trimmed := strings.TrimSpace(id)
+	if strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">") {
+		trimmed = trimmed[1 : len(trimmed)-1]
+	}
+	left, right, found := strings.Cut(trimmed, "@")
+	if !found || left == "" || right == "" || strings.ContainsAny(left+right, "<>@ \t\r\n") {
+		return "", fmt.Errorf("invalid message ID %q", id)
+	}
+	return "<" + trimmed + ">", nil
+}
+
+// validateHeader returns an error if name is no valid RFC5322 field name,
+// is reserved, value contains line breaks or value cannot be folded into
+// lines of at most 998 characters. Non-ASCII values are RFC2047 encoded
+// into short encoded words and can always be folded.
+func validateHeader(name string, value string) error {
+	if name == "" {
+		return fmt.Errorf("header name is empty")
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' || name[i] == ':' {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if reservedHeaders[textproto.CanonicalMIMEHeaderKey(name)] {
+		return fmt.Errorf("header %s cannot be set directly", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("header %s contains a line break", name)
+	}
+	if encodeHeader(value) == value {
+		for _, word := range headerWords(" " + value) {
+			if len(name)+1+len(word) > maxLineLength {
+				return fmt.Errorf("header %s contains a word too long to be folded", name)
+			}
+		}
+	}
+	return nil
+}
+
+// writeHeaders writes header sorted by name with encoded values.
+func writeHeaders(w io.StringWriter, header textproto.MIMEHeader) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			w.WriteString(foldHeader(name, encodeHeader(value)))
+		}
+	}
+}
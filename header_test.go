@@ -0,0 +1,161 @@
+package tinymail
+
+import (
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFoldHeader(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("Subject: short\r\n", foldHeader("Subject", "short"))
+
+	long := strings.TrimSpace(strings.Repeat("folded words ", 20))
+	folded := foldHeader("Subject", long)
+	test.True(strings.HasSuffix(folded, "\r\n"))
+	lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+	test.Greater(len(lines), 1)
+	for i, line := range lines {
+		test.LessOrEqual(len(line), 78)
+		if i > 0 {
+			test.True(strings.HasPrefix(line, " "))
+		}
+	}
+	test.Equal("Subject: "+long, strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n", ""))
+}
+
+func TestFoldHeaderLineBreaks(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("Subject: no injection\r\n", foldHeader("Subject", "no\r\ninjection"))
+	test.Equal("Subject: no injection\r\n", foldHeader("Subject", "no\ninjection"))
+}
+
+func TestFoldHeaderLongWord(t *testing.T) {
+	test := assert.New(t)
+
+	word := strings.Repeat("x", 100)
+	test.Equal("Subject: "+word+"\r\n", foldHeader("Subject", word))
+	test.Equal("Subject: a\r\n "+word+"\r\n", foldHeader("Subject", "a "+word))
+
+	for _, value := range []string{strings.Repeat("ä", 1200), strings.Repeat("a ", 1200)} {
+		for _, line := range strings.Split(foldHeader("X-Tag", encodeHeader(value)), "\r\n") {
+			test.LessOrEqual(len(line), maxLineLength)
+		}
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	test := assert.New(t)
+	decoder := &mime.WordDecoder{}
+
+	test.Equal("plain subject", encodeHeader("plain subject"))
+
+	for _, value := range []string{
+		"Größenänderung der Bestellung",
+		"日本語の件名",
+		strings.Repeat("Prüfung ", 20),
+	} {
+		encoded := encodeHeader(value)
+		test.NotEqual(value, encoded)
+		for i := 0; i < len(encoded); i++ {
+			test.Less(encoded[i], byte(0x80))
+		}
+		decoded, err := decoder.DecodeHeader(encoded)
+		test.NoError(err)
+		test.Equal(value, decoded)
+	}
+	test.True(strings.HasPrefix(encodeHeader("Größenänderung"), "=?utf-8?q?"))
+	test.True(strings.HasPrefix(encodeHeader("日本語"), "=?utf-8?b?"))
+}
+
+func TestFormatAddressList(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("test@tinymail.test", formatAddress(&mail.Address{Address: "test@tinymail.test"}))
+	test.Equal(`"Jane Doe" <jane@tinymail.test>`, formatAddress(&mail.Address{Name: "Jane Doe", Address: "jane@tinymail.test"}))
+	test.Equal("=?utf-8?q?J=C3=BCrgen_M=C3=BCller?= <juergen@tinymail.test>", formatAddress(&mail.Address{Name: "Jürgen Müller", Address: "juergen@tinymail.test"}))
+	test.Equal(
+		`test@tinymail.test, "Jane Doe" <jane@tinymail.test>`,
+		formatAddressList([]*mail.Address{
+			{Address: "test@tinymail.test"},
+			{Name: "Jane Doe", Address: "jane@tinymail.test"},
+		}),
+	)
+}
+
+func TestWithFilename(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("attachment; filename=report.pdf", withFilename("attachment", "filename", "report.pdf"))
+	test.Equal(`application/pdf; name="my report.pdf"`, withFilename("application/pdf", "name", "my report.pdf"))
+	test.Equal(`text/csv; charset=utf-8; name=export.csv`, withFilename("text/csv; charset=utf-8", "name", "export.csv"))
+	test.Equal("invalid;;", withFilename("invalid;;", "name", "export.csv"))
+
+	for _, filename := range []string{
+		"report.pdf",
+		"my report.pdf",
+		"a;b=c.pdf",
+		`quote "and" backslash\.pdf`,
+		"Rechnung März.pdf",
+		"報告書.pdf",
+		`Größe "x".pdf`,
+		`Größe\x.pdf`,
+		"",
+	} {
+		for _, value := range []string{"attachment", "inline", "application/pdf"} {
+			formatted := withFilename(value, "filename", filename)
+			for i := 0; i < len(formatted); i++ {
+				test.Less(formatted[i], byte(0x80))
+			}
+			mediaType, params, err := mime.ParseMediaType(formatted)
+			test.NoError(err)
+			test.Equal(value, mediaType)
+			test.Equal(filename, params["filename"])
+		}
+	}
+
+	formatted := withFilename("attachment", "filename", "Rechnung März.pdf")
+	test.Equal(`attachment; filename="=?utf-8?q?Rechnung_M=C3=A4rz.pdf?="; filename*=utf-8''Rechnung%20M%C3%A4rz.pdf`, formatted)
+}
+
+func TestParseMessageID(t *testing.T) {
+	test := assert.New(t)
+
+	for id, want := range map[string]string{
+		"a@b":           "<a@b>",
+		"<a@b>":         "<a@b>",
+		" <a.b@c.d> ":   "<a.b@c.d>",
+		"<a@[1.2.3.4]>": "<a@[1.2.3.4]>",
+	} {
+		got, err := parseMessageID(id)
+		test.NoError(err, id)
+		test.Equal(want, got)
+	}
+	for _, id := range []string{"", "<>", "a", "@b", "a@", "<a@b", "a@b@c", "a b@c", "a@b\r\nX: y"} {
+		_, err := parseMessageID(id)
+		test.Error(err, id)
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	test := assert.New(t)
+
+	test.NoError(validateHeader("X-Campaign-ID", "42"))
+	test.NoError(validateHeader("List-Unsubscribe", "<mailto:unsubscribe@tinymail.test>"))
+	test.Error(validateHeader("X Tag", "value"))
+	test.Error(validateHeader("X-Tä", "value"))
+	test.Error(validateHeader("content-id", "value"))
+	test.Error(validateHeader("X-Tag", "a\rb"))
+	test.Error(validateHeader("X-Tag", "a\x00b"))
+
+	test.NoError(validateHeader("X-Tag", strings.Repeat("a", 991)))
+	test.Error(validateHeader("X-Tag", strings.Repeat("a", 992)))
+	test.Error(validateHeader("X-Tag", "a "+strings.Repeat("a", 1200)))
+	test.NoError(validateHeader("X-Tag", strings.Repeat("a ", 1200)))
+	test.NoError(validateHeader("X-Tag", strings.Repeat("ä", 1200)))
+}
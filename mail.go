@@ -4,21 +4,57 @@ package tinymail
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
-	"net/http"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 const DEFAULT_SMTP_PORT int = 587
+const DEFAULT_SMTPS_PORT int = 465
+const DEFAULT_SMTP_PLAIN_PORT int = 25
+
+const DEFAULT_DIAL_TIMEOUT time.Duration = 30 * time.Second
+const DEFAULT_COMMAND_TIMEOUT time.Duration = 5 * time.Minute
+const DEFAULT_DATA_TIMEOUT time.Duration = 10 * time.Minute
+
+// Security defines how the connection to the SMTP server is secured.
+type Security int
+
+const (
+	// SecurityOpportunistic upgrades the connection with STARTTLS
+	// if the server supports it and sends unencrypted otherwise.
+	// Credentials are never sent unencrypted, so without STARTTLS
+	// authentication fails unless the server is localhost.
+	SecurityOpportunistic Security = iota
+	// SecurityStartTLS upgrades the connection with STARTTLS and fails
+	// if the server does not support it.
+	SecurityStartTLS
+	// SecurityTLS connects with implicit TLS (SMTPS).
+	SecurityTLS
+	// SecurityNone never encrypts the connection.
+	// Credentials are sent unencrypted.
+	SecurityNone
+)
 
 type Mailer interface {
-	Send() error
+	Send(msg Message) error
+	SendContext(ctx context.Context, msg Message) error
 	SetBoundary(boundary string)
 	Boundary() string
 	Config() *smtpConfig
@@ -28,26 +64,52 @@ type MailerOpts struct {
 	User     string
 	Password string
 	Host     string
-	Port     int
+	// Port defaults to a port matching Security if empty.
+	Port int
+	// Deprecated: TLS selects [SecurityStartTLS] if Security is not set. Use Security instead.
 	TLS      bool
+	Security Security
+	// TLSConfig is used for STARTTLS and implicit TLS.
+	// ServerName defaults to Host if empty.
+	TLSConfig *tls.Config
+	// DialTimeout limits connecting to the server including the TLS handshake.
+	DialTimeout time.Duration
+	// CommandTimeout limits every SMTP command.
+	CommandTimeout time.Duration
+	// DataTimeout limits transferring the message.
+	DataTimeout time.Duration
+	// MessageIDDomain is the right part of generated Message-IDs.
+	// It defaults to the domain of the sender address or Host.
+	MessageIDDomain string
 }
 
 type smtpConfig struct {
-	auth     smtp.Auth
-	user     string
-	password string
-	host     string
-	addr     string
-	port     int
-	tls      bool
+	auth            smtp.Auth
+	user            string
+	password        string
+	host            string
+	addr            string
+	port            int
+	security        Security
+	tlsConfig       *tls.Config
+	dialTimeout     time.Duration
+	commandTimeout  time.Duration
+	dataTimeout     time.Duration
+	messageIDDomain string
 }
 
 type mailer struct {
-	message  Message
 	boundary string
 	config   *smtpConfig
 }
 
+// smtpConn guards the deadlines of a connection against cancellation.
+type smtpConn struct {
+	net.Conn
+	mu       sync.Mutex
+	canceled bool
+}
+
 type smtpLoginAuth struct {
 	username, password string
 }
@@ -59,16 +121,40 @@ func New(opts MailerOpts) (*mailer, error) {
 	if err := validateMailerOpts(opts); err != nil {
 		return nil, err
 	}
+	if opts.TLS && opts.Security == SecurityOpportunistic {
+		opts.Security = SecurityStartTLS
+	}
 	if opts.Port == 0 {
-		opts.Port = DEFAULT_SMTP_PORT
+		opts.Port = defaultPort(opts.Security)
+	}
+	tlsConfig := &tls.Config{}
+	if opts.TLSConfig != nil {
+		tlsConfig = opts.TLSConfig.Clone()
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if opts.CommandTimeout == 0 {
+		opts.CommandTimeout = DEFAULT_COMMAND_TIMEOUT
+	}
+	if opts.DataTimeout == 0 {
+		opts.DataTimeout = DEFAULT_DATA_TIMEOUT
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = opts.Host
 	}
 	c := &smtpConfig{
-		user:     opts.User,
-		password: opts.Password,
-		host:     opts.Host,
-		port:     opts.Port,
-		addr:     fmt.Sprintf("%s:%d", opts.Host, opts.Port),
-		tls:      opts.TLS,
+		user:            opts.User,
+		password:        opts.Password,
+		host:            opts.Host,
+		port:            opts.Port,
+		addr:            fmt.Sprintf("%s:%d", opts.Host, opts.Port),
+		security:        opts.Security,
+		tlsConfig:       tlsConfig,
+		dialTimeout:     opts.DialTimeout,
+		commandTimeout:  opts.CommandTimeout,
+		dataTimeout:     opts.DataTimeout,
+		messageIDDomain: opts.MessageIDDomain,
 	}
 	c.auth = smtp.PlainAuth("", c.user, c.password, c.host)
 	m := &mailer{
@@ -77,11 +163,26 @@ func New(opts MailerOpts) (*mailer, error) {
 	return m, nil
 }
 
+// defaultPort returns the conventional port for security.
+func defaultPort(security Security) int {
+	switch security {
+	case SecurityTLS:
+		return DEFAULT_SMTPS_PORT
+	case SecurityNone:
+		return DEFAULT_SMTP_PLAIN_PORT
+	default:
+		return DEFAULT_SMTP_PORT
+	}
+}
+
 // validateMailerOpts returns an error if
 //
 //   - [MailerOpts.User] is empty
 //   - [MailerOpts.Password] is empty
 //   - [MailerOpts.Host] is empty
+//   - [MailerOpts.Security] is unknown
+//   - one of the timeouts is negative
+//   - [MailerOpts.MessageIDDomain] is no valid domain
 func validateMailerOpts(opts MailerOpts) error {
 	if opts.User == "" {
 		return fmt.Errorf("MailerOpts.User is empty")
@@ -92,58 +193,148 @@ func validateMailerOpts(opts MailerOpts) error {
 	if opts.Host == "" {
 		return fmt.Errorf("MailerOpts.Host ist empty")
 	}
+	if opts.Security < SecurityOpportunistic || opts.Security > SecurityNone {
+		return fmt.Errorf("MailerOpts.Security is unknown")
+	}
+	if opts.DialTimeout < 0 || opts.CommandTimeout < 0 || opts.DataTimeout < 0 {
+		return fmt.Errorf("MailerOpts timeouts must not be negative")
+	}
+	if strings.ContainsAny(opts.MessageIDDomain, "<>@ \t\r\n") {
+		return fmt.Errorf("MailerOpts.MessageIDDomain is invalid")
+	}
 	return nil
 }
 
-// Send sends the message with [net/smtp.SendMail]
-func (m *mailer) Send() error {
-	if m.config.tls {
-		return m.sendTLS()
-	} else {
-		return m.sendPlain()
+// Send sends msg to the SMTP server secured as configured by [MailerOpts.Security].
+//
+// Send is safe for concurrent use with different messages, every call uses its
+// own connection. The same message must not be sent concurrently, as its date
+// and Message-ID are set when it is sent, see [mailer.SendContext].
+func (m *mailer) Send(msg Message) error {
+	return m.SendContext(context.Background(), msg)
+}
+
+// SendContext sends msg like [mailer.Send].
+//
+// If msg has no date or Message-ID, the current time and a generated
+// Message-ID are set on msg before sending, so [Message.MessageID]
+// returns the ID of the sent message. This modifies msg, so it must not be
+// used concurrently while it is sent.
+//
+// Cancelling ctx aborts the SMTP conversation and returns the context error.
+func (m *mailer) SendContext(ctx context.Context, msg Message) (err error) {
+	if err := m.setDefaults(msg); err != nil {
+		return err
+	}
+	conn, client, err := m.dial(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
 	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.cancel()
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
 
+	conn.timeout(m.config.commandTimeout)
+	c, err := smtp.NewClient(client, m.config.host)
+	if err != nil {
+		return err
+	}
+	return m.send(c, conn, msg)
 }
 
-func (m *mailer) sendTLS() error {
-	tlsConfig := &tls.Config{
-		ServerName: m.config.host,
+// setDefaults sets the date and Message-ID of msg if they are missing.
+func (m *mailer) setDefaults(msg Message) error {
+	if msg.Date().IsZero() {
+		msg.SetDate(time.Now())
+	}
+	if msg.MessageID() != "" {
+		return nil
 	}
-	c, err := smtp.Dial(m.config.addr)
+	id, err := m.newMessageID(msg)
 	if err != nil {
 		return err
 	}
+	return msg.SetMessageID(id)
+}
 
-	if err = c.StartTLS(tlsConfig); err != nil {
+// newMessageID returns a unique Message-ID for msg with the domain
+// of [MailerOpts.MessageIDDomain], the sender or the SMTP host.
+func (m *mailer) newMessageID(msg Message) (string, error) {
+	domain := m.config.messageIDDomain
+	if domain == "" && msg.From() != nil {
+		_, domain, _ = strings.Cut(msg.From().Address, "@")
+	}
+	if domain == "" {
+		domain = m.config.host
+	}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%s.%s@%s>", strconv.FormatInt(time.Now().UnixNano(), 36), hex.EncodeToString(random), domain), nil
+}
+
+// send runs the SMTP conversation for msg on c, limiting each step with a timeout on conn.
+func (m *mailer) send(c *smtp.Client, conn *smtpConn, msg Message) error {
+	conn.timeout(m.config.commandTimeout)
+	if err := m.startTLS(c); err != nil {
 		return err
 	}
+	auth := m.config.auth
 	if ok, auths := c.Extension("AUTH"); ok {
 		if strings.Contains(auths, "LOGIN") &&
 			!strings.Contains(auths, "PLAIN") {
-			m.config.auth = loginAuth(m.config.user, m.config.password)
+			auth = loginAuth(m.config.user, m.config.password)
 		}
 	} else {
 		return errors.New("no authentication method found")
 	}
+	if m.config.security == SecurityNone {
+		auth = unencryptedAuth{auth}
+	}
 
-	if err = c.Auth(m.config.auth); err != nil {
+	conn.timeout(m.config.commandTimeout)
+	if err := c.Auth(auth); err != nil {
 		return err
 	}
 
-	if err = c.Mail(m.config.user); err != nil {
+	conn.timeout(m.config.commandTimeout)
+	if err := c.Mail(envelopeSender(msg, m.config.user)); err != nil {
 		return err
 	}
 
-	for _, rcpt := range m.message.To() {
-		if err = c.Rcpt(rcpt); err != nil {
+	for _, rcpt := range recipients(msg) {
+		conn.timeout(m.config.commandTimeout)
+		if err := c.Rcpt(rcpt); err != nil {
 			return err
 		}
 	}
+	conn.timeout(m.config.commandTimeout)
 	writer, err := c.Data()
 	if err != nil {
 		return err
 	}
-	_, err = writer.Write(m.writeMessage())
+	conn.timeout(m.config.dataTimeout)
+	err = m.writeMessage(writer, msg)
 	if err != nil {
 		return err
 	}
@@ -152,20 +343,96 @@ func (m *mailer) sendTLS() error {
 		return err
 	}
 
+	conn.timeout(m.config.commandTimeout)
 	return c.Quit()
 }
 
-func (m *mailer) sendPlain() error {
-	return smtp.SendMail(m.config.addr, m.config.auth, m.config.user, m.message.To(), m.writeMessage())
+// envelopeSender returns the return path of msg or user if none is set.
+func envelopeSender(msg Message, user string) string {
+	if msg.ReturnPath() != nil {
+		return msg.ReturnPath().Address
+	}
+	return user
 }
 
-// SetMessage sets the message
-func (m *mailer) SetMessage(msg Message) *mailer {
-	m.message = msg
-	return m
+// recipients returns the deduplicated envelope recipients of msg
+// which are the To, CC and BCC recipients.
+func recipients(msg Message) []string {
+	var rcpts []string
+	seen := map[string]bool{}
+	for _, list := range [][]*mail.Address{msg.To(), msg.CC(), msg.BCC()} {
+		for _, rcpt := range list {
+			if seen[rcpt.Address] {
+				continue
+			}
+			seen[rcpt.Address] = true
+			rcpts = append(rcpts, rcpt.Address)
+		}
+	}
+	return rcpts
+}
+
+// dial connects to the SMTP server and returns the connection and the
+// connection to speak SMTP on. For [SecurityTLS] the latter is a *tls.Conn
+// over the connection, which net/smtp requires to detect the encryption.
+func (m *mailer) dial(ctx context.Context) (*smtpConn, net.Conn, error) {
+	dialer := &net.Dialer{Timeout: m.config.dialTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp", m.config.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn := &smtpConn{Conn: raw}
+	if m.config.security != SecurityTLS {
+		return conn, conn, nil
+	}
+	conn.timeout(m.config.dialTimeout)
+	tlsConn := tls.Client(conn, m.config.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, tlsConn, nil
+}
+
+// timeout sets the deadline of the connection d from now,
+// unless the connection was canceled.
+func (c *smtpConn) timeout(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.canceled {
+		c.Conn.SetDeadline(time.Now().Add(d))
+	}
+}
+
+// cancel aborts pending and future I/O on the connection.
+func (c *smtpConn) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.canceled = true
+	c.Conn.SetDeadline(time.Now())
+}
+
+// startTLS upgrades the connection with STARTTLS if required by [MailerOpts.Security].
+//
+// Returns an error if STARTTLS is mandatory but not supported by the server.
+func (m *mailer) startTLS(c *smtp.Client) error {
+	switch m.config.security {
+	case SecurityStartTLS:
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		return c.StartTLS(m.config.tlsConfig)
+	case SecurityOpportunistic:
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			return c.StartTLS(m.config.tlsConfig)
+		}
+	}
+	return nil
 }
 
 // SetBoundary sets the boundary string
+//
+// SetBoundary must not be called concurrently with [mailer.Send].
 func (m *mailer) SetBoundary(boundary string) *mailer {
 	m.boundary = boundary
 	return m
@@ -181,90 +448,298 @@ func (m *mailer) Config() *smtpConfig {
 	return m.config
 }
 
-// splits s line by line into RFC5322 compliant chunks
-func (m *mailer) chunkLines(s string) string {
-	scanner := bufio.NewScanner(strings.NewReader(s))
-	var chunkedLines []string
-	for scanner.Scan() {
-		chunkedLines = append(chunkedLines, m.chunkString(scanner.Text()))
+// transferEncoding returns the Content-Transfer-Encoding for a text body.
+//
+// ASCII text without lines longer than 998 bytes (RFC5322 2.1.1) is sent 7bit,
+// mostly ASCII text quoted-printable to stay readable and everything else base64.
+func transferEncoding(body string) string {
+	nonASCII := 0
+	lineLength := 0
+	longLines := false
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c == '\r' || c == '\n' {
+			lineLength = 0
+			continue
+		}
+		if c >= utf8.RuneSelf || c == 0 {
+			nonASCII++
+		}
+		lineLength++
+		if lineLength > 998 {
+			longLines = true
+		}
+	}
+	switch {
+	case nonASCII == 0 && !longLines:
+		return "7bit"
+	case nonASCII > len(body)/3:
+		return "base64"
+	default:
+		return "quoted-printable"
 	}
+}
 
-	return strings.Join(chunkedLines, "\n")
+// crlfLines returns s with every line ending replaced by CRLF.
+func crlfLines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
 }
 
-// chunk e mail into parts of 998 characters due to
-// RFC5322 2.1.1 Line Length Limits
-func (m *mailer) chunkString(s string) string {
-	var chunks []string
-	for len(s) > 998 {
-		chunks = append(chunks, s[:998])
-		s = s[998:]
+// writeBase64 writes r base64 encoded in lines of 76 characters
+// as required by RFC2045 6.8.
+func writeBase64(w io.Writer, r io.Reader) error {
+	encoder := base64.NewEncoder(base64.StdEncoding, &lineWriter{w: w, length: 76})
+	if _, err := io.Copy(encoder, r); err != nil {
+		return err
 	}
-	chunks = append(chunks, s)
-	return strings.Join(chunks, "\n")
+	return encoder.Close()
 }
 
-// writeMessage writes the message
-func (m *mailer) writeMessage() []byte {
-	msg := m.message
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString("MIME-Version: 1.0\n")
-	withAttachments := len(msg.Attachments()) > 0
-	buf.WriteString(fmt.Sprintf("From: %s\n", msg.From()))
-	buf.WriteString(fmt.Sprintf("To: %s\n", strings.Join(msg.To(), ",")))
-	buf.WriteString(fmt.Sprintf("Subject: %s\n", msg.Subject()))
-	if len(msg.CC()) > 0 {
-		buf.WriteString(fmt.Sprintf("Cc: %s\n", strings.Join(msg.CC(), ",")))
+// lineWriter breaks everything written into lines of length joined by CRLF.
+type lineWriter struct {
+	w      io.Writer
+	length int
+	// n is the length of the current line.
+	n int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.n == l.length {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return written, err
+			}
+			l.n = 0
+		}
+		chunk := p
+		if len(chunk) > l.length-l.n {
+			chunk = chunk[:l.length-l.n]
+		}
+		n, err := l.w.Write(chunk)
+		written += n
+		l.n += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
 	}
+	return written, nil
+}
 
-	if len(msg.BCC()) > 0 {
-		buf.WriteString(fmt.Sprintf("Bcc: %s\n", strings.Join(msg.BCC(), ",")))
+// writeMessage writes msg to w with CRLF line endings as described in RFC5322.
+// Attachments are streamed from their source while writing.
+//
+// Date and Message-ID are only written if set on msg, see [mailer.SendContext].
+// BCC recipients are part of the envelope only and never written.
+func (m *mailer) writeMessage(w io.Writer, msg Message) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString(foldHeader("MIME-Version", "1.0"))
+	if !msg.Date().IsZero() {
+		buf.WriteString(foldHeader("Date", msg.Date().Format(time.RFC1123Z)))
+	}
+	if msg.MessageID() != "" {
+		buf.WriteString(foldHeader("Message-ID", msg.MessageID()))
+	}
+	withAttachments := len(filterAttachments(msg, dispositionAttachment)) > 0
+	if msg.From() != nil {
+		buf.WriteString(foldHeader("From", formatAddress(msg.From())))
+	}
+	if msg.Sender() != nil {
+		buf.WriteString(foldHeader("Sender", formatAddress(msg.Sender())))
+	}
+	if len(msg.ReplyTo()) > 0 {
+		buf.WriteString(foldHeader("Reply-To", formatAddressList(msg.ReplyTo())))
+	}
+	if len(msg.To()) > 0 {
+		buf.WriteString(foldHeader("To", formatAddressList(msg.To())))
+	}
+	buf.WriteString(foldHeader("Subject", encodeHeader(msg.Subject())))
+	if len(msg.CC()) > 0 {
+		buf.WriteString(foldHeader("Cc", formatAddressList(msg.CC())))
+	}
+	if msg.InReplyTo() != "" {
+		buf.WriteString(foldHeader("In-Reply-To", msg.InReplyTo()))
+		buf.WriteString(foldHeader("References", strings.Join(msg.References(), " ")))
 	}
 	if len(msg.Priority()) > 0 {
-		buf.WriteString(fmt.Sprintf("Priority: %s\n", msg.Priority()))
+		buf.WriteString(foldHeader("Priority", msg.Priority()))
 	}
+	writeHeaders(buf, msg.Header())
 
-	writer := multipart.NewWriter(buf)
-	var boundary string
-	if len(m.boundary) > 0 {
-		boundary = m.boundary
+	var err error
+	if withAttachments {
+		err = m.writeMixed(buf, msg)
 	} else {
-		boundary = writer.Boundary()
+		err = m.writeBody(buf, msg)
+	}
+	if err != nil {
+		return err
 	}
+	return buf.Flush()
+}
 
-	if withAttachments {
-		buf.WriteString(fmt.Sprintf("Content-Type: multipart/mixed;\n boundary=%s\n\n", boundary))
-		buf.WriteString(fmt.Sprintf("--%s\n", boundary))
+// writeMixed writes the body and attachments of msg as multipart/mixed.
+func (m *mailer) writeMixed(buf *bufio.Writer, msg Message) error {
+	boundary := m.newBoundary("")
+	buf.WriteString(foldHeader("Content-Type", multipartType("mixed", boundary)))
+	buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+	if err := m.writeBody(buf, msg); err != nil {
+		return err
+	}
+	for _, file := range filterAttachments(msg, dispositionAttachment) {
+		buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+		if err := writeAttachment(buf, file); err != nil {
+			return err
+		}
+	}
+	buf.WriteString(fmt.Sprintf("\r\n--%s--\r\n", boundary))
+	return nil
+}
+
+// filterAttachments returns the files of msg with disposition in their original order.
+func filterAttachments(msg Message, disposition string) []*Attachment {
+	var files []*Attachment
+	for _, file := range msg.Attachments() {
+		if file.disposition == disposition {
+			files = append(files, file)
+		}
+	}
+	return files
+}
 
+// writeAttachment writes file as a base64 encoded part,
+// with a Content-ID if it is embedded.
+//
+// Returns an error if the file could not be read.
+func writeAttachment(buf *bufio.Writer, file *Attachment) error {
+	buf.WriteString(foldHeader("Content-Type", withFilename(file.contentType, "name", file.filename)))
+	buf.WriteString(foldHeader("Content-Transfer-Encoding", "base64"))
+	if len(file.contentID) > 0 {
+		buf.WriteString(foldHeader("Content-ID", "<"+file.contentID+">"))
 	}
-	buf.WriteString(fmt.Sprintf("Content-Type: %s\n\n", http.DetectContentType([]byte(msg.Body()))))
-	buf.WriteString(m.chunkLines(msg.Body()))
-	if withAttachments {
-		for k, v := range msg.Attachments() {
-			buf.WriteString(fmt.Sprintf("\n--%s\n", boundary))
-			buf.WriteString(fmt.Sprintf("Content-Type: %s\n", http.DetectContentType(v)))
-			buf.WriteString("Content-Transfer-Encoding: base64\n")
-			buf.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=%s\n\n", k))
-
-			b := make([]byte, base64.StdEncoding.EncodedLen(len(v)))
-			base64.StdEncoding.Encode(b, v)
-			buf.Write([]byte(m.chunkString(string(b))))
-			buf.WriteString(fmt.Sprintf("\n--%s", boundary))
+	buf.WriteString(foldHeader("Content-Disposition", withFilename(file.disposition, "filename", file.filename)))
+	buf.WriteString("\r\n")
+	content, err := file.open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return writeBase64(buf, content)
+}
+
+// writeBody writes the body of msg, which is multipart/alternative
+// if msg has both a text and a HTML body.
+func (m *mailer) writeBody(buf *bufio.Writer, msg Message) error {
+	text, html := msg.TextBody(), msg.HTMLBody()
+	switch {
+	case len(text) > 0 && len(html) > 0:
+		boundary := m.newBoundary("alt_")
+		buf.WriteString(foldHeader("Content-Type", multipartType("alternative", boundary)))
+		buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+		writeTextPart(buf, "text/plain; charset=utf-8", text)
+		buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+		if err := m.writeRelated(buf, msg, "text/html; charset=utf-8", html); err != nil {
+			return err
 		}
+		buf.WriteString(fmt.Sprintf("\r\n--%s--\r\n", boundary))
+		return nil
+	case len(html) > 0:
+		return m.writeRelated(buf, msg, "text/html; charset=utf-8", html)
+	default:
+		return m.writeRelated(buf, msg, "text/plain; charset=utf-8", text)
+	}
+}
 
-		buf.WriteString("--")
+// writeRelated writes body as a part of contentType, wrapped in
+// multipart/related together with the embedded files of msg if there are any.
+func (m *mailer) writeRelated(buf *bufio.Writer, msg Message, contentType string, body string) error {
+	embeds := filterAttachments(msg, dispositionInline)
+	if len(embeds) == 0 {
+		writeTextPart(buf, contentType, body)
+		return nil
 	}
-	return buf.Bytes()
+	boundary := m.newBoundary("rel_")
+	rootType, _, _ := strings.Cut(contentType, ";")
+	buf.WriteString(foldHeader("Content-Type", mime.FormatMediaType("multipart/related", map[string]string{
+		"boundary": boundary,
+		"type":     rootType,
+	})))
+	buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+	writeTextPart(buf, contentType, body)
+	for _, file := range embeds {
+		buf.WriteString(fmt.Sprintf("\r\n--%s\r\n", boundary))
+		if err := writeAttachment(buf, file); err != nil {
+			return err
+		}
+	}
+	buf.WriteString(fmt.Sprintf("\r\n--%s--\r\n", boundary))
+	return nil
+}
+
+// writeTextPart writes body as a single part of contentType
+// with the transfer encoding chosen by [transferEncoding].
+func writeTextPart(buf *bufio.Writer, contentType string, body string) {
+	encoding := transferEncoding(body)
+	buf.WriteString(foldHeader("Content-Type", contentType))
+	buf.WriteString(foldHeader("Content-Transfer-Encoding", encoding))
+	buf.WriteString("\r\n")
+	switch encoding {
+	case "quoted-printable":
+		writer := quotedprintable.NewWriter(buf)
+		writer.Write([]byte(body))
+		writer.Close()
+	case "base64":
+		writeBase64(buf, strings.NewReader(crlfLines(body)))
+	default:
+		buf.WriteString(crlfLines(body))
+	}
+}
+
+// newBoundary returns a random boundary or, if set, the boundary
+// of the mailer. Nested multiparts have to use distinct prefixes.
+func (m *mailer) newBoundary(prefix string) string {
+	if len(m.boundary) > 0 {
+		return prefix + m.boundary
+	}
+	return multipart.NewWriter(io.Discard).Boundary()
+}
+
+// multipartType returns the Content-Type of a multipart subtype.
+func multipartType(subtype string, boundary string) string {
+	return mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary})
+}
+
+// unencryptedAuth allows Auth to send credentials over an unencrypted connection.
+type unencryptedAuth struct {
+	smtp.Auth
+}
+
+func (a unencryptedAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	encrypted := *server
+	encrypted.TLS = true
+	return a.Auth.Start(&encrypted)
 }
 
 func loginAuth(username, password string) smtp.Auth {
 	return &smtpLoginAuth{username, password}
 }
 
+// Start refuses unencrypted connections to hosts other than localhost like [smtp.PlainAuth].
 func (a *smtpLoginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
 	return "LOGIN", []byte{}, nil
 }
 
+// isLocalhost reports whether name is the local host as in [smtp.PlainAuth].
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
 func (a *smtpLoginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
 	if more {
 		switch string(fromServer) {
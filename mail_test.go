@@ -1,8 +1,27 @@
 package tinymail
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,6 +54,187 @@ var CUSTOM_PORT_MAILER_OPTS = MailerOpts{
 	Port:     123,
 }
 
+type testMail struct {
+	from string
+	rcpt []string
+	data string
+}
+
+type testServerOpts struct {
+	// host is the address to listen on, 127.0.0.1 if empty. Other loopback
+	// addresses like 127.0.0.2 are not treated as localhost by net/smtp.
+	host string
+	// implicitTLS makes the listener speak TLS.
+	implicitTLS bool
+	// starttls makes the server advertise STARTTLS.
+	starttls bool
+	// stall makes the server never respond.
+	stall bool
+}
+
+// testServer is a minimal SMTP server recording received mails.
+type testServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	opts      testServerOpts
+	mu        sync.Mutex
+	mails     []testMail
+}
+
+// newTestServer starts a test server on localhost.
+func newTestServer(t *testing.T, opts testServerOpts) *testServer {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	tlsConfig := &tls.Config{Certificates: srv.TLS.Certificates}
+	srv.Close()
+
+	if opts.host == "" {
+		opts.host = "127.0.0.1"
+	}
+	var listener net.Listener
+	var err error
+	if opts.implicitTLS {
+		listener, err = tls.Listen("tcp", opts.host+":0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", opts.host+":0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		opts:      opts,
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// mailerOpts returns valid MailerOpts for the test server trusting its certificate,
+// which is issued for example.com.
+func (s *testServer) mailerOpts(security Security) MailerOpts {
+	pool := x509.NewCertPool()
+	cert, _ := x509.ParseCertificate(s.tlsConfig.Certificates[0].Certificate[0])
+	pool.AddCert(cert)
+	return MailerOpts{
+		User:      "test",
+		Password:  "secret",
+		Host:      s.opts.host,
+		Port:      s.listener.Addr().(*net.TCPAddr).Port,
+		Security:  security,
+		TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"},
+	}
+}
+
+// received returns the mails received so far.
+func (s *testServer) received() []testMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testMail{}, s.mails...)
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	if s.opts.stall {
+		conn.Read(make([]byte, 1))
+		return
+	}
+	_, secure := conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 tinymail test server")
+	var mail testMail
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-tinymail")
+			if s.opts.starttls && !secure {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "STARTTLS":
+			text.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			text.PrintfLine("235 authenticated")
+		case "MAIL":
+			mail = testMail{from: envelopeAddress(arg)}
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			mail.rcpt = append(mail.rcpt, envelopeAddress(arg))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			text.PrintfLine("250 ok")
+		case "RSET", "NOOP":
+			text.PrintfLine("250 ok")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 unknown command")
+		}
+	}
+}
+
+// envelopeAddress extracts the address from a MAIL FROM or RCPT TO argument.
+func envelopeAddress(arg string) string {
+	_, addr, _ := strings.Cut(arg, "<")
+	addr, _, _ = strings.Cut(addr, ">")
+	return addr
+}
+
+// writeMessage returns msg as written by mailer.
+func writeMessage(t *testing.T, mailer *mailer, msg Message) []byte {
+	buf := bytes.Buffer{}
+	assert.NoError(t, mailer.writeMessage(&buf, msg))
+	return buf.Bytes()
+}
+
+// decodeBody returns the content of r decoded with transfer encoding.
+func decodeBody(t *testing.T, encoding string, r io.Reader) []byte {
+	switch encoding {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return content
+}
+
+// crlf replaces the line endings of s with CRLF.
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
 func TestWriteMessage(t *testing.T) {
 	test := assert.New(t)
 
@@ -43,8 +243,8 @@ From: test@tinymail.test
 To: test.to@tinymail.test
 Subject: TestWriteMessage
 Cc: test.cc@tinymail.test
-Bcc: test.bcc@tinymail.test
 Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
 
 this is a test`
 
@@ -58,9 +258,7 @@ this is a test`
 	msg.SetCC("test.cc@tinymail.test")
 	msg.SetBCC("test.bcc@tinymail.test")
 
-	mailer.SetMessage(msg)
-
-	test.Equal(want, string(mailer.writeMessage()))
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
 }
 
 func TestWriteMessageUrgent(t *testing.T) {
@@ -71,9 +269,9 @@ From: test@tinymail.test
 To: test.to@tinymail.test
 Subject: TestWriteMessageUrgent
 Cc: test.cc@tinymail.test
-Bcc: test.bcc@tinymail.test
 Priority: urgent
 Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
 
 this is a test`
 
@@ -88,8 +286,117 @@ this is a test`
 	msg.SetBCC("test.bcc@tinymail.test")
 	msg.SetUrgentPriority()
 
-	mailer.SetMessage(msg)
-	test.Equal(want, string(mailer.writeMessage()))
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
+}
+
+func TestWriteMessageDateMessageID(t *testing.T) {
+	test := assert.New(t)
+
+	want := `MIME-Version: 1.0
+Date: Fri, 16 Oct 2026 09:30:00 +0200
+Message-ID: <1234@tinymail.test>
+Subject: TestWriteMessageDateMessageID
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+this is a test`
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetSubject("TestWriteMessageDateMessageID")
+	msg.SetDate(time.Date(2026, 10, 16, 9, 30, 0, 0, time.FixedZone("CEST", 2*60*60)))
+	test.NoError(msg.SetMessageID("1234@tinymail.test"))
+
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
+}
+
+func TestWriteMessageHeaders(t *testing.T) {
+	test := assert.New(t)
+
+	want := `MIME-Version: 1.0
+Subject: TestWriteMessageHeaders
+List-Id: Tinymail Newsletter <newsletter.tinymail.test>
+X-Campaign-Id: =?utf-8?q?Fr=C3=BChling?=
+X-Tag: first
+X-Tag: second
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+this is a test`
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetSubject("TestWriteMessageHeaders")
+	test.NoError(msg.SetHeader("X-Tag", "first"))
+	test.NoError(msg.AddHeader("x-tag", "second"))
+	test.NoError(msg.SetHeader("X-Campaign-ID", "Frühling"))
+	test.NoError(msg.SetHeader("List-Id", "Tinymail Newsletter <newsletter.tinymail.test>"))
+
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
+}
+
+func TestWriteMessageReplyTo(t *testing.T) {
+	test := assert.New(t)
+
+	want := `MIME-Version: 1.0
+From: "Support" <support@tinymail.test>
+Sender: noreply-relay@tinymail.test
+Reply-To: "Ticket 42" <ticket+42@tinymail.test>, support@tinymail.test
+To: test.to@tinymail.test
+Subject: TestWriteMessageReplyTo
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+this is a test`
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetFrom("Support <support@tinymail.test>")
+	msg.SetSender("noreply-relay@tinymail.test")
+	msg.SetReplyTo("Ticket 42 <ticket+42@tinymail.test>", "support@tinymail.test")
+	msg.SetReturnPath("bounces@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+	msg.SetSubject("TestWriteMessageReplyTo")
+
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
+}
+
+func TestWriteMessageReply(t *testing.T) {
+	test := assert.New(t)
+
+	want := `MIME-Version: 1.0
+To: test.to@tinymail.test
+Subject: Re: TestWriteMessageReply
+Cc: test.cc@tinymail.test
+In-Reply-To: <parent.0123456789abcdef0123456789@tinymail.test>
+References: <root.0123456789abcdef0123456789@tinymail.test>
+ <child.0123456789abcdef0123456789@tinymail.test>
+ <parent.0123456789abcdef0123456789@tinymail.test>
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+this is a test`
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+	msg.SetCC("test.cc@tinymail.test")
+	msg.SetSubject("TestWriteMessageReply")
+	test.NoError(msg.SetInReplyTo(
+		"<parent.0123456789abcdef0123456789@tinymail.test>",
+		"<root.0123456789abcdef0123456789@tinymail.test>",
+		"<child.0123456789abcdef0123456789@tinymail.test>",
+	))
+
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
 }
 
 func TestWriteMessageAttach(t *testing.T) {
@@ -100,21 +407,30 @@ From: test@tinymail.test
 To: test.to@tinymail.test
 Subject: TestWriteMessageAttach
 Cc: test.cc@tinymail.test
-Bcc: test.bcc@tinymail.test
 Content-Type: multipart/mixed;
  boundary=7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
 
 --7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
 Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
 
 this is a test
 --7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
-Content-Type: application/octet-stream
+Content-Type: application/octet-stream; name=TestWriteMessageAttach
 Content-Transfer-Encoding: base64
 Content-Disposition: attachment; filename=TestWriteMessageAttach
 
-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
---7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0--`
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+--7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0--
+`
 
 	os.WriteFile("TestWriteMessageAttach", make([]byte, 512), 0644)
 
@@ -131,12 +447,364 @@ AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
 	msg.SetBCC("test.bcc@tinymail.test")
 	msg.Attach("TestWriteMessageAttach")
 
-	mailer.SetMessage(msg)
-
-	test.Equal(want, string(mailer.writeMessage()))
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
 	test.NoError(os.Remove("TestWriteMessageAttach"))
 }
 
+func TestWriteMessageParse(t *testing.T) {
+	test := assert.New(t)
+
+	os.WriteFile("TestWriteMessageParse", []byte("attachment content"), 0644)
+	defer os.Remove("TestWriteMessageParse")
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	long := strings.Repeat("ä", 600)
+	msg := FromString("first line\nsecond line\r\n" + long)
+	msg.SetFrom("test@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+	msg.SetSubject("TestWriteMessageParse")
+	test.NoError(msg.Attach("TestWriteMessageParse"))
+
+	raw := writeMessage(t, mailer, msg)
+	for _, line := range strings.Split(string(raw), "\r\n") {
+		test.NotContains(line, "\n")
+		test.LessOrEqual(len(line), 998)
+		test.True(utf8.ValidString(line))
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	test.NoError(err)
+	test.Equal("TestWriteMessageParse", parsed.Header.Get("Subject"))
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("multipart/mixed", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	part, err := reader.NextPart()
+	test.NoError(err)
+	test.Equal("base64", part.Header.Get("Content-Transfer-Encoding"))
+	content := decodeBody(t, part.Header.Get("Content-Transfer-Encoding"), part)
+	lines := strings.SplitN(string(content), "\r\n", 3)
+	test.Equal([]string{"first line", "second line"}, lines[:2])
+	test.Equal(long, strings.ReplaceAll(lines[2], "\r\n", ""))
+
+	part, err = reader.NextPart()
+	test.NoError(err)
+	test.Equal("TestWriteMessageParse", part.FileName())
+	content = decodeBody(t, part.Header.Get("Content-Transfer-Encoding"), part)
+	test.Equal("attachment content", string(content))
+
+	_, err = reader.NextPart()
+	test.Equal(io.EOF, err)
+}
+
+func TestWriteMessageNonASCII(t *testing.T) {
+	test := assert.New(t)
+
+	os.WriteFile("Prüfbericht März.pdf", []byte("attachment content"), 0644)
+	defer os.Remove("Prüfbericht März.pdf")
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetFrom("Jürgen Müller <juergen@tinymail.test>")
+	msg.SetTo("Zoë <zoe@tinymail.test>", "test.to@tinymail.test")
+	msg.SetSubject("Größenänderung Ihrer Bestellung")
+	test.NoError(msg.Attach("Prüfbericht März.pdf"))
+
+	raw := writeMessage(t, mailer, msg)
+	headers, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	for i := 0; i < len(headers); i++ {
+		test.Less(headers[i], byte(0x80))
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	test.NoError(err)
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(parsed.Header.Get("Subject"))
+	test.NoError(err)
+	test.Equal("Größenänderung Ihrer Bestellung", subject)
+
+	from, err := parsed.Header.AddressList("From")
+	test.NoError(err)
+	test.Equal([]*mail.Address{{Name: "Jürgen Müller", Address: "juergen@tinymail.test"}}, from)
+	to, err := parsed.Header.AddressList("To")
+	test.NoError(err)
+	test.Equal([]*mail.Address{
+		{Name: "Zoë", Address: "zoe@tinymail.test"},
+		{Address: "test.to@tinymail.test"},
+	}, to)
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	test.NoError(err)
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	_, err = reader.NextPart()
+	test.NoError(err)
+	part, err := reader.NextPart()
+	test.NoError(err)
+	test.Equal("Prüfbericht März.pdf", part.FileName())
+	_, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("Prüfbericht März.pdf", params["name"])
+}
+
+func TestWriteMessageAlternative(t *testing.T) {
+	test := assert.New(t)
+
+	want := `MIME-Version: 1.0
+From: test@tinymail.test
+To: test.to@tinymail.test
+Subject: TestWriteMessageAlternative
+Content-Type: multipart/alternative;
+ boundary=alt_7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
+
+--alt_7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+this is a test
+--alt_7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: 7bit
+
+<p>this is a test</p>
+--alt_7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0--
+`
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	mailer.SetBoundary("7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0")
+
+	msg := FromString("")
+	msg.SetFrom("test@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+	msg.SetSubject("TestWriteMessageAlternative")
+	msg.SetTextBody("this is a test")
+	msg.SetHTMLBody("<p>this is a test</p>")
+
+	test.Equal(crlf(want), string(writeMessage(t, mailer, msg)))
+}
+
+func TestWriteMessageAlternativeAttach(t *testing.T) {
+	test := assert.New(t)
+
+	os.WriteFile("TestWriteMessageAlternativeAttach", []byte("attachment content"), 0644)
+	defer os.Remove("TestWriteMessageAlternativeAttach")
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("")
+	msg.SetTo("test.to@tinymail.test")
+	msg.SetTextBody("this is a test")
+	msg.SetHTMLBody("<p>this is a test</p>")
+	test.NoError(msg.Attach("TestWriteMessageAlternativeAttach"))
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(writeMessage(t, mailer, msg)))
+	test.NoError(err)
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("multipart/mixed", mediaType)
+	mixed := multipart.NewReader(parsed.Body, params["boundary"])
+
+	part, err := mixed.NextPart()
+	test.NoError(err)
+	mediaType, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("multipart/alternative", mediaType)
+	alternative := multipart.NewReader(part, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "this is a test"},
+		{"text/html; charset=utf-8", "<p>this is a test</p>"},
+	} {
+		part, err := alternative.NextPart()
+		test.NoError(err)
+		test.Equal(want.contentType, part.Header.Get("Content-Type"))
+		body, err := io.ReadAll(part)
+		test.NoError(err)
+		test.Equal(want.body, string(body))
+	}
+	_, err = alternative.NextPart()
+	test.Equal(io.EOF, err)
+
+	part, err = mixed.NextPart()
+	test.NoError(err)
+	test.Equal("TestWriteMessageAlternativeAttach", part.FileName())
+	_, err = mixed.NextPart()
+	test.Equal(io.EOF, err)
+}
+
+func TestWriteMessageEmbed(t *testing.T) {
+	test := assert.New(t)
+
+	os.WriteFile("TestWriteMessageEmbed.png", []byte("image content"), 0644)
+	defer os.Remove("TestWriteMessageEmbed.png")
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("")
+	msg.SetTo("test.to@tinymail.test")
+	cid, err := msg.Embed("TestWriteMessageEmbed.png")
+	test.NoError(err)
+	msg.SetTextBody("this is a test")
+	msg.SetHTMLBody(`<img src="` + cid + `">`)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(writeMessage(t, mailer, msg)))
+	test.NoError(err)
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("multipart/alternative", mediaType)
+	alternative := multipart.NewReader(parsed.Body, params["boundary"])
+
+	part, err := alternative.NextPart()
+	test.NoError(err)
+	test.Equal("text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+
+	part, err = alternative.NextPart()
+	test.NoError(err)
+	mediaType, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	test.NoError(err)
+	test.Equal("multipart/related", mediaType)
+	test.Equal("text/html", params["type"])
+	related := multipart.NewReader(part, params["boundary"])
+
+	part, err = related.NextPart()
+	test.NoError(err)
+	test.Equal("text/html; charset=utf-8", part.Header.Get("Content-Type"))
+	body, err := io.ReadAll(part)
+	test.NoError(err)
+	test.Equal(`<img src="cid:TestWriteMessageEmbed.png@tinymail">`, string(body))
+
+	part, err = related.NextPart()
+	test.NoError(err)
+	test.Equal("image/png; name=TestWriteMessageEmbed.png", part.Header.Get("Content-Type"))
+	test.Equal("<TestWriteMessageEmbed.png@tinymail>", part.Header.Get("Content-ID"))
+	test.Equal("inline; filename=TestWriteMessageEmbed.png", part.Header.Get("Content-Disposition"))
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	test.NoError(err)
+	test.Equal("image content", string(content))
+
+	_, err = related.NextPart()
+	test.Equal(io.EOF, err)
+	_, err = alternative.NextPart()
+	test.Equal(io.EOF, err)
+}
+
+func TestWriteMessageAttachOrder(t *testing.T) {
+	test := assert.New(t)
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	names := []string{"report.pdf", "z.txt", "a.txt", "report.pdf", "m.csv"}
+	for i, name := range names {
+		msg.AttachBytes(name, []byte(fmt.Sprint(i)), "")
+	}
+
+	for i := 0; i < 5; i++ {
+		parsed, err := mail.ReadMessage(bytes.NewReader(writeMessage(t, mailer, msg)))
+		test.NoError(err)
+		_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		test.NoError(err)
+		reader := multipart.NewReader(parsed.Body, params["boundary"])
+		_, err = reader.NextPart()
+		test.NoError(err)
+		for i, name := range names {
+			part, err := reader.NextPart()
+			test.NoError(err)
+			test.Equal(name, part.FileName())
+			content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+			test.NoError(err)
+			test.Equal(fmt.Sprint(i), string(content))
+		}
+	}
+	mailer.SetBoundary("7b7f6c9583aae2870247062aac5ca1bc1610b22b627ae2c5366bb1394ed0")
+	first := writeMessage(t, mailer, msg)
+	test.Equal(first, writeMessage(t, mailer, msg))
+}
+
+func TestWriteMessageStreamError(t *testing.T) {
+	test := assert.New(t)
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.AttachReader("once.txt", strings.NewReader("content"), "")
+	test.NoError(mailer.writeMessage(io.Discard, msg))
+	test.Error(mailer.writeMessage(io.Discard, msg))
+}
+
+func TestLineWriter(t *testing.T) {
+	test := assert.New(t)
+
+	buf := bytes.Buffer{}
+	w := &lineWriter{w: &buf, length: 4}
+	for _, s := range []string{"ab", "cdefghij", "", "k", "l"} {
+		n, err := w.Write([]byte(s))
+		test.NoError(err)
+		test.Equal(len(s), n)
+	}
+	test.Equal("abcd\r\nefgh\r\nijkl", buf.String())
+}
+
+func TestWriteMessageTransferEncoding(t *testing.T) {
+	test := assert.New(t)
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	minified := "<html><body>" + strings.Repeat(`<p class="x">minified</p>`, 100) + "</body></html>"
+	for _, tc := range []struct {
+		body     string
+		encoding string
+	}{
+		{"plain ascii\nwith lines", "7bit"},
+		{"Grüße aus München\nmit Umlauten", "quoted-printable"},
+		{minified, "quoted-printable"},
+		{"日本語のテキスト", "base64"},
+		{"first line\nsecond line\r\n" + strings.Repeat("ä", 600), "base64"},
+	} {
+		msg := FromString("")
+		msg.SetHTMLBody(tc.body)
+
+		raw := writeMessage(t, mailer, msg)
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			test.LessOrEqual(len(line), 998)
+			for i := 0; i < len(line); i++ {
+				test.Less(line[i], byte(0x80))
+			}
+		}
+
+		parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+		test.NoError(err)
+		test.Equal(tc.encoding, parsed.Header.Get("Content-Transfer-Encoding"))
+		test.Equal(crlfLines(tc.body), string(decodeBody(t, parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body)))
+	}
+}
+
+func TestTransferEncoding(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("7bit", transferEncoding(""))
+	test.Equal("7bit", transferEncoding(strings.Repeat("x", 998)+"\r\n"+strings.Repeat("x", 998)))
+	test.Equal("quoted-printable", transferEncoding(strings.Repeat("x", 999)))
+	test.Equal("quoted-printable", transferEncoding("null\x00byte"))
+	test.Equal("quoted-printable", transferEncoding("Grüße aus München"))
+	test.Equal("base64", transferEncoding("Ωμέγα"))
+}
+
+func TestCRLFLines(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal("a\r\nb\r\nc\r\nd", crlfLines("a\nb\r\nc\rd"))
+}
+
 func TestDefaultPortOpt(t *testing.T) {
 	test := assert.New(t)
 
@@ -183,3 +851,340 @@ func TestCustomPortInMailerOpts(t *testing.T) {
 	config := mailer.Config()
 	test.Equal(123, config.port)
 }
+
+func TestDefaultPortForSecurity(t *testing.T) {
+	test := assert.New(t)
+
+	for security, port := range map[Security]int{
+		SecurityOpportunistic: DEFAULT_SMTP_PORT,
+		SecurityStartTLS:      DEFAULT_SMTP_PORT,
+		SecurityTLS:           DEFAULT_SMTPS_PORT,
+		SecurityNone:          DEFAULT_SMTP_PLAIN_PORT,
+	} {
+		opts := VALID_MAILER_OPTS
+		opts.Security = security
+		mailer, err := New(opts)
+		test.NoError(err)
+		test.Equal(port, mailer.Config().port)
+		test.Equal(security, mailer.Config().security)
+	}
+}
+
+func TestLegacyTLSOpt(t *testing.T) {
+	test := assert.New(t)
+
+	opts := VALID_MAILER_OPTS
+	opts.TLS = true
+	mailer, err := New(opts)
+	test.NoError(err)
+	test.Equal(SecurityStartTLS, mailer.Config().security)
+}
+
+func TestUnknownSecurityInMailerOpts(t *testing.T) {
+	test := assert.New(t)
+
+	opts := VALID_MAILER_OPTS
+	opts.Security = Security(42)
+	mailer, err := New(opts)
+	test.Error(err)
+	test.Nil(mailer)
+}
+
+func TestSend(t *testing.T) {
+	for name, tc := range map[string]struct {
+		server   testServerOpts
+		security Security
+	}{
+		"none":          {security: SecurityNone},
+		"opportunistic": {server: testServerOpts{starttls: true}, security: SecurityOpportunistic},
+		"plain":         {security: SecurityOpportunistic},
+		"starttls":      {server: testServerOpts{starttls: true}, security: SecurityStartTLS},
+		"implicit tls":  {server: testServerOpts{implicitTLS: true}, security: SecurityTLS},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test := assert.New(t)
+			server := newTestServer(t, tc.server)
+
+			mailer, err := New(server.mailerOpts(tc.security))
+			test.NoError(err)
+
+			msg := FromString("this is a test")
+			msg.SetFrom("test@tinymail.test")
+			msg.SetTo("test.to@tinymail.test")
+			msg.SetSubject("TestSend")
+
+			test.NoError(mailer.Send(msg))
+			mails := server.received()
+			if test.Len(mails, 1) {
+				test.Equal("test", mails[0].from)
+				test.Equal([]string{"test.to@tinymail.test"}, mails[0].rcpt)
+				test.Contains(mails[0].data, "this is a test")
+			}
+		})
+	}
+}
+
+func TestSendDateMessageID(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{})
+
+	mailer, err := New(server.mailerOpts(SecurityNone))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetFrom("test@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+
+	before := time.Now().Truncate(time.Second)
+	test.NoError(mailer.Send(msg))
+	test.Regexp(`^<[0-9a-z]+\.[0-9a-f]{24}@tinymail\.test>$`, msg.MessageID())
+	test.False(msg.Date().Before(before))
+
+	other := FromString("this is a test")
+	other.SetTo("test.to@tinymail.test")
+	test.NoError(mailer.Send(other))
+	test.Regexp(`@127\.0\.0\.1>$`, other.MessageID())
+	test.NotEqual(msg.MessageID(), other.MessageID())
+
+	mails := server.received()
+	if test.Len(mails, 2) {
+		parsed, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+		test.NoError(err)
+		test.Equal(msg.MessageID(), parsed.Header.Get("Message-ID"))
+		date, err := parsed.Header.Date()
+		test.NoError(err)
+		test.True(date.Equal(msg.Date().Truncate(time.Second)))
+	}
+
+	opts := server.mailerOpts(SecurityNone)
+	opts.MessageIDDomain = "mail.tinymail.test"
+	mailer, err = New(opts)
+	test.NoError(err)
+	msg = FromString("this is a test")
+	msg.SetFrom("test@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+	test.NoError(mailer.Send(msg))
+	test.Regexp(`@mail\.tinymail\.test>$`, msg.MessageID())
+
+	msg = FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+	test.NoError(msg.SetMessageID("<custom@tinymail.test>"))
+	test.NoError(mailer.Send(msg))
+	test.Equal("<custom@tinymail.test>", msg.MessageID())
+}
+
+func TestSendReturnPath(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{})
+
+	mailer, err := New(server.mailerOpts(SecurityNone))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetFrom("support@tinymail.test")
+	msg.SetTo("test.to@tinymail.test")
+	test.NoError(mailer.Send(msg))
+
+	test.NoError(msg.SetReturnPath("Bounces <bounces@tinymail.test>"))
+	test.NoError(mailer.Send(msg))
+
+	mails := server.received()
+	if test.Len(mails, 2) {
+		test.Equal("test", mails[0].from)
+		test.Equal("bounces@tinymail.test", mails[1].from)
+		test.NotContains(mails[1].data, "bounces@tinymail.test")
+		test.Contains(mails[1].data, "From: support@tinymail.test")
+	}
+}
+
+func TestInvalidMessageIDDomainInMailerOpts(t *testing.T) {
+	test := assert.New(t)
+	opts := VALID_MAILER_OPTS
+	opts.MessageIDDomain = "tinymail test"
+	mailer, err := New(opts)
+	test.Error(err)
+	test.Nil(mailer)
+}
+
+func TestSendRemoteHost(t *testing.T) {
+	for name, tc := range map[string]struct {
+		server   testServerOpts
+		security Security
+	}{
+		"none":          {server: testServerOpts{host: "127.0.0.2"}, security: SecurityNone},
+		"opportunistic": {server: testServerOpts{host: "127.0.0.2", starttls: true}, security: SecurityOpportunistic},
+		"starttls":      {server: testServerOpts{host: "127.0.0.2", starttls: true}, security: SecurityStartTLS},
+		"implicit tls":  {server: testServerOpts{host: "127.0.0.2", implicitTLS: true}, security: SecurityTLS},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test := assert.New(t)
+			server := newTestServer(t, tc.server)
+
+			mailer, err := New(server.mailerOpts(tc.security))
+			test.NoError(err)
+
+			msg := FromString("this is a test")
+			msg.SetTo("test.to@tinymail.test")
+
+			test.NoError(mailer.Send(msg))
+			test.Len(server.received(), 1)
+		})
+	}
+}
+
+func TestSendRemoteHostUnencrypted(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{host: "127.0.0.2"})
+
+	mailer, err := New(server.mailerOpts(SecurityOpportunistic))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+
+	test.ErrorContains(mailer.Send(msg), "unencrypted connection")
+	test.Empty(server.received())
+}
+
+func TestLoginAuth(t *testing.T) {
+	test := assert.New(t)
+	auth := loginAuth("test", "secret")
+
+	_, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.tinymail.test"})
+	test.Error(err)
+	for _, server := range []*smtp.ServerInfo{
+		{Name: "mail.tinymail.test", TLS: true},
+		{Name: "localhost"},
+	} {
+		proto, _, err := auth.Start(server)
+		test.NoError(err)
+		test.Equal("LOGIN", proto)
+	}
+	proto, _, err := unencryptedAuth{auth}.Start(&smtp.ServerInfo{Name: "mail.tinymail.test"})
+	test.NoError(err)
+	test.Equal("LOGIN", proto)
+
+	response, err := auth.Next([]byte("Username:"), true)
+	test.NoError(err)
+	test.Equal("test", string(response))
+	response, err = auth.Next([]byte("Password:"), true)
+	test.NoError(err)
+	test.Equal("secret", string(response))
+}
+
+func TestSendStartTLSUnsupported(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{})
+
+	mailer, err := New(server.mailerOpts(SecurityStartTLS))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+
+	test.Error(mailer.Send(msg))
+	test.Empty(server.received())
+}
+
+func TestDefaultTimeouts(t *testing.T) {
+	test := assert.New(t)
+
+	mailer, err := New(VALID_MAILER_OPTS)
+	test.NoError(err)
+
+	config := mailer.Config()
+	test.Equal(DEFAULT_DIAL_TIMEOUT, config.dialTimeout)
+	test.Equal(DEFAULT_COMMAND_TIMEOUT, config.commandTimeout)
+	test.Equal(DEFAULT_DATA_TIMEOUT, config.dataTimeout)
+}
+
+func TestSendCommandTimeout(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{stall: true})
+
+	opts := server.mailerOpts(SecurityNone)
+	opts.CommandTimeout = 50 * time.Millisecond
+	mailer, err := New(opts)
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+
+	err = mailer.Send(msg)
+	var netErr net.Error
+	if test.ErrorAs(err, &netErr) {
+		test.True(netErr.Timeout())
+	}
+}
+
+func TestSendContextCanceled(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{stall: true})
+
+	mailer, err := New(server.mailerOpts(SecurityNone))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	test.ErrorIs(mailer.SendContext(ctx, msg), context.DeadlineExceeded)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	test.ErrorIs(mailer.SendContext(ctx, msg), context.Canceled)
+}
+
+func TestSendConcurrent(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{})
+
+	mailer, err := New(server.mailerOpts(SecurityNone))
+	test.NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := FromString(fmt.Sprintf("message %d", i))
+			msg.SetTo(fmt.Sprintf("test.to%d@tinymail.test", i))
+			test.NoError(mailer.Send(msg))
+		}(i)
+	}
+	wg.Wait()
+
+	mails := server.received()
+	test.Len(mails, 10)
+	for _, mail := range mails {
+		test.Len(mail.rcpt, 1)
+		test.Contains(mail.data, "message "+strings.TrimSuffix(strings.TrimPrefix(mail.rcpt[0], "test.to"), "@tinymail.test"))
+	}
+}
+
+func TestSendRecipients(t *testing.T) {
+	test := assert.New(t)
+	server := newTestServer(t, testServerOpts{})
+
+	mailer, err := New(server.mailerOpts(SecurityNone))
+	test.NoError(err)
+
+	msg := FromString("this is a test")
+	msg.SetTo("test.to@tinymail.test", "test.both@tinymail.test")
+	msg.SetCC("Test CC <test.cc@tinymail.test>", "test.both@tinymail.test")
+	msg.SetBCC("test.bcc@tinymail.test", "Test To <test.to@tinymail.test>")
+
+	test.NoError(mailer.Send(msg))
+	mails := server.received()
+	if test.Len(mails, 1) {
+		test.Equal([]string{
+			"test.to@tinymail.test",
+			"test.both@tinymail.test",
+			"test.cc@tinymail.test",
+			"test.bcc@tinymail.test",
+		}, mails[0].rcpt)
+		test.NotContains(mails[0].data, "Bcc")
+		test.NotContains(mails[0].data, "test.bcc@tinymail.test")
+	}
+}
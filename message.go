@@ -1,116 +1,499 @@
 package tinymail
 
 import (
-	"bytes"
+	"fmt"
 	"html/template"
-	"os"
+	"io"
+	"io/fs"
+	"net/mail"
+	"net/textproto"
+	"path"
 	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
 )
 
 type Message interface {
-	SetFrom(from string)
-	From() string
-	SetTo(to ...string)
-	To() []string
-	SetCC(cc ...string)
-	CC() []string
-	SetBCC(bcc ...string)
-	BCC() []string
+	SetFrom(from string) error
+	From() *mail.Address
+	SetSender(sender string) error
+	Sender() *mail.Address
+	SetReplyTo(replyTo ...string) error
+	ReplyTo() []*mail.Address
+	SetReturnPath(returnPath string) error
+	ReturnPath() *mail.Address
+	SetTo(to ...string) error
+	To() []*mail.Address
+	SetCC(cc ...string) error
+	CC() []*mail.Address
+	SetBCC(bcc ...string) error
+	BCC() []*mail.Address
 	SetSubject(s string)
 	Subject() string
+	SetDate(date time.Time)
+	Date() time.Time
+	SetMessageID(id string) error
+	MessageID() string
+	SetInReplyTo(parentID string, references ...string) error
+	InReplyTo() string
+	References() []string
+	SetHeader(name string, value string) error
+	AddHeader(name string, value string) error
+	Header() textproto.MIMEHeader
 	Attach(files ...string) error
-	Attachments() map[string][]byte
-	Body() string
+	AttachWithType(file string, contentType string) error
+	AttachReader(name string, r io.Reader, contentType string)
+	AttachBytes(name string, data []byte, contentType string)
+	AttachFS(fsys fs.FS, file string) error
+	Attachments() []*Attachment
+	Embed(file string) (string, error)
+	EmbedFS(fsys fs.FS, file string) (string, error)
+	EmbedBytes(name string, data []byte, contentType string) (string, error)
+	SetTextBody(text string)
+	TextBody() string
+	SetHTMLBody(html string)
+	HTMLBody() string
 	SetUrgentPriority()
 	SetNonUrgentPriority()
 	SetNormalPriority()
 	Priority() string
 }
 type message struct {
-	from        string
-	to          []string
-	cc          []string
-	bcc         []string
+	from        *mail.Address
+	sender      *mail.Address
+	replyTo     []*mail.Address
+	returnPath  *mail.Address
+	to          []*mail.Address
+	cc          []*mail.Address
+	bcc         []*mail.Address
 	subject     string
-	body        string
+	text        string
+	html        string
 	priority    string
-	attachments map[string][]byte
+	date        time.Time
+	messageID   string
+	inReplyTo   string
+	references  []string
+	header      textproto.MIMEHeader
+	attachments []*Attachment
 }
 
-// SetFrom sets the sender email.
-func (m *message) SetFrom(from string) {
-	m.from = from
+// SetFrom sets the sender address, e.g. "Jane Doe <jane@example.com>".
+//
+// Returns an error if from is not a valid RFC5322 address.
+func (m *message) SetFrom(from string) error {
+	addresses, err := parseAddresses(from)
+	if err != nil {
+		return err
+	}
+	m.from = addresses[0]
+	return nil
 }
 
-// From returns the sender email.
-func (m *message) From() string {
+// From returns the sender address.
+func (m *message) From() *mail.Address {
 	return m.from
 }
 
+// SetSender sets the Sender header, the address actually sending the
+// message on behalf of the From address.
+//
+// Returns an error if sender is not a valid RFC5322 address.
+func (m *message) SetSender(sender string) error {
+	addresses, err := parseAddresses(sender)
+	if err != nil {
+		return err
+	}
+	m.sender = addresses[0]
+	return nil
+}
+
+// Sender returns the Sender address.
+func (m *message) Sender() *mail.Address {
+	return m.sender
+}
+
+// SetReplyTo sets the addresses replies should be sent to.
+//
+// Returns an error if one of replyTo is not a valid RFC5322 address.
+func (m *message) SetReplyTo(replyTo ...string) error {
+	addresses, err := parseAddresses(replyTo...)
+	if err != nil {
+		return err
+	}
+	m.replyTo = addresses
+	return nil
+}
+
+// ReplyTo returns the Reply-To addresses.
+func (m *message) ReplyTo() []*mail.Address {
+	return m.replyTo
+}
+
+// SetReturnPath sets the envelope sender (SMTP MAIL FROM) bounces are sent to.
+// It is not written as header, the receiving server adds the Return-Path.
+//
+// If no return path is set, the user of the mailer is used.
+// Returns an error if returnPath is not a valid RFC5322 address.
+func (m *message) SetReturnPath(returnPath string) error {
+	addresses, err := parseAddresses(returnPath)
+	if err != nil {
+		return err
+	}
+	m.returnPath = addresses[0]
+	return nil
+}
+
+// ReturnPath returns the envelope sender.
+func (m *message) ReturnPath() *mail.Address {
+	return m.returnPath
+}
+
 // SetTo sets the receiver addresses.
-func (m *message) SetTo(to ...string) {
-	m.to = to
+//
+// Returns an error if one of to is not a valid RFC5322 address.
+func (m *message) SetTo(to ...string) error {
+	addresses, err := parseAddresses(to...)
+	if err != nil {
+		return err
+	}
+	m.to = addresses
+	return nil
 }
 
 // To returns receivers.
-func (m *message) To() []string {
+func (m *message) To() []*mail.Address {
 	return m.to
 }
 
 // SetCC sets the CC recipients.
-func (m *message) SetCC(cc ...string) {
-	m.cc = cc
+//
+// Returns an error if one of cc is not a valid RFC5322 address.
+func (m *message) SetCC(cc ...string) error {
+	addresses, err := parseAddresses(cc...)
+	if err != nil {
+		return err
+	}
+	m.cc = addresses
+	return nil
 }
 
 // CC returns the CC recipients.
-func (m *message) CC() []string {
+func (m *message) CC() []*mail.Address {
 	return m.cc
 }
 
 // SetBCC sets the BCC recipients.
-func (m *message) SetBCC(bcc ...string) {
-	m.bcc = bcc
+//
+// Returns an error if one of bcc is not a valid RFC5322 address.
+func (m *message) SetBCC(bcc ...string) error {
+	addresses, err := parseAddresses(bcc...)
+	if err != nil {
+		return err
+	}
+	m.bcc = addresses
+	return nil
 }
 
 // BCC returns the BCC recipients.
-func (m *message) BCC() []string {
+func (m *message) BCC() []*mail.Address {
 	return m.bcc
 }
 
+// parseAddresses parses each of addresses into display name and addr-spec.
+//
+// Returns an error naming the first address which could not be parsed.
+func parseAddresses(addresses ...string) ([]*mail.Address, error) {
+	parsed := make([]*mail.Address, len(addresses))
+	for i, address := range addresses {
+		a, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		parsed[i] = a
+	}
+	return parsed, nil
+}
+
 // SetSubject sets the subject.
 func (m *message) SetSubject(subject string) {
 	m.subject = subject
 }
 
 // Subject returns the Subject.
+//
+// Replies are prefixed with "Re: " unless the subject already is, see [message.SetInReplyTo].
 func (m *message) Subject() string {
+	if m.inReplyTo != "" && !strings.HasPrefix(strings.ToLower(m.subject), "re:") {
+		return "Re: " + m.subject
+	}
 	return m.subject
 }
 
-// Attach attaches files
+// SetDate sets the origination date.
+//
+// If no date is set, the time of sending is used.
+func (m *message) SetDate(date time.Time) {
+	m.date = date
+}
+
+// Date returns the origination date.
+func (m *message) Date() time.Time {
+	return m.date
+}
+
+// SetMessageID sets the Message-ID, e.g. "<id@example.com>".
+// The angle brackets are optional.
+//
+// If no Message-ID is set, one is generated and set when the message is sent.
+// Returns an error if id is not a valid RFC5322 msg-id.
+func (m *message) SetMessageID(id string) error {
+	msgID, err := parseMessageID(id)
+	if err != nil {
+		return err
+	}
+	m.messageID = msgID
+	return nil
+}
+
+// MessageID returns the Message-ID including angle brackets.
+func (m *message) MessageID() string {
+	return m.messageID
+}
+
+// SetInReplyTo makes the message a reply to the message with parentID,
+// e.g. the [message.MessageID] of a sent message, so that it is threaded by
+// mail clients. references are the References of the parent message.
+//
+// In-Reply-To is set to parentID and References to references followed by
+// parentID. The subject is prefixed with "Re: " once.
+// Returns an error if one of the IDs is not a valid RFC5322 msg-id.
+func (m *message) SetInReplyTo(parentID string, references ...string) error {
+	parent, err := parseMessageID(parentID)
+	if err != nil {
+		return err
+	}
+	refs := make([]string, 0, len(references)+1)
+	for _, reference := range references {
+		ref, err := parseMessageID(reference)
+		if err != nil {
+			return err
+		}
+		if ref != parent {
+			refs = append(refs, ref)
+		}
+	}
+	m.inReplyTo = parent
+	m.references = append(refs, parent)
+	return nil
+}
+
+// InReplyTo returns the Message-ID of the parent message.
+func (m *message) InReplyTo() string {
+	return m.inReplyTo
+}
+
+// References returns the Message-IDs of the thread, ending with the parent message.
+func (m *message) References() []string {
+	return m.references
+}
+
+// SetHeader sets the custom header name to value, replacing existing values.
+//
+// Returns an error if name is invalid, value contains line breaks or a word
+// longer than an RFC5322 line or name is written by tinymail itself,
+// like From or Content-Type.
+func (m *message) SetHeader(name string, value string) error {
+	if err := validateHeader(name, value); err != nil {
+		return err
+	}
+	if m.header == nil {
+		m.header = textproto.MIMEHeader{}
+	}
+	m.header.Set(name, value)
+	return nil
+}
+
+// AddHeader adds value to the custom header name like [message.SetHeader]
+// but keeps existing values.
+func (m *message) AddHeader(name string, value string) error {
+	if err := validateHeader(name, value); err != nil {
+		return err
+	}
+	if m.header == nil {
+		m.header = textproto.MIMEHeader{}
+	}
+	m.header.Add(name, value)
+	return nil
+}
+
+// Header returns a copy of the custom headers.
+func (m *message) Header() textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader, len(m.header))
+	for name, values := range m.header {
+		header[name] = append([]string{}, values...)
+	}
+	return header
+}
+
+// Attach attaches files with a content type derived from their extension.
+// The files are read when the message is sent.
 //
-// Returns an error if one of files could not be read.
+// Returns an error if one of files does not exist.
 func (m *message) Attach(files ...string) error {
 	for _, file := range files {
-		b, err := os.ReadFile(file)
-		if err != nil {
+		if err := m.AttachWithType(file, ""); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		_, fileName := filepath.Split(file)
-		m.attachments[fileName] = b
+// AttachWithType attaches file with contentType. If contentType is empty
+// it is derived from the file extension like in [message.Attach].
+//
+// Returns an error if file does not exist.
+func (m *message) AttachWithType(file string, contentType string) error {
+	_, fileName := filepath.Split(file)
+	if contentType == "" {
+		contentType = contentTypeByName(fileName)
+	}
+	a, err := fileAttachment(file, contentType)
+	if err != nil {
+		return err
 	}
+	m.attach(fileName, a)
 	return nil
 }
 
-// Attachments returns the attachments.
-func (m *message) Attachments() map[string][]byte {
-	return m.attachments
+// AttachReader attaches the content of r as file name. If contentType
+// is empty it is derived from the extension of name.
+//
+// r is read when the message is sent, so the message can only be sent once.
+func (m *message) AttachReader(name string, r io.Reader, contentType string) {
+	if contentType == "" {
+		contentType = contentTypeByName(name)
+	}
+	m.attach(name, readerAttachment(name, r, contentType))
 }
 
-// Body returns the body.
-func (m *message) Body() string {
-	return m.body
+// AttachBytes attaches data as file name. If contentType
+// is empty it is derived from the extension of name.
+func (m *message) AttachBytes(name string, data []byte, contentType string) {
+	if contentType == "" {
+		contentType = contentTypeByName(name)
+	}
+	m.attach(name, bytesAttachment(data, contentType))
+}
+
+// AttachFS attaches file from fsys, e.g. an [embed.FS], with a
+// content type derived from its extension. The file is read when
+// the message is sent.
+//
+// Returns an error if file does not exist in fsys.
+func (m *message) AttachFS(fsys fs.FS, file string) error {
+	fileName := path.Base(file)
+	a, err := fsAttachment(fsys, file, contentTypeByName(fileName))
+	if err != nil {
+		return err
+	}
+	m.attach(fileName, a)
+	return nil
+}
+
+// attach appends a as attachment with file name.
+func (m *message) attach(name string, a *Attachment) {
+	a.filename = name
+	a.disposition = dispositionAttachment
+	m.attachments = append(m.attachments, a)
+}
+
+// Attachments returns a copy of the attached and embedded files in the order they were added.
+func (m *message) Attachments() []*Attachment {
+	return append([]*Attachment(nil), m.attachments...)
+}
+
+// Embed embeds file as inline part of the HTML body, e.g. an image.
+//
+// Returns the cid: URL to reference file in the HTML body, like
+// <img src="cid:logo.png@tinymail">. The content ID is derived from the file
+// name with [ContentID], so it can be used in templates before the file is embedded.
+// Returns an error if file does not exist or a file with the same
+// content ID is already embedded.
+func (m *message) Embed(file string) (string, error) {
+	_, fileName := filepath.Split(file)
+	a, err := fileAttachment(file, contentTypeByName(fileName))
+	if err != nil {
+		return "", err
+	}
+	return m.embed(fileName, a)
+}
+
+// EmbedFS embeds file from fsys, e.g. an [embed.FS], like [message.Embed].
+// The file is read when the message is sent.
+//
+// Returns an error if file does not exist in fsys or a file with the same
+// content ID is already embedded.
+func (m *message) EmbedFS(fsys fs.FS, file string) (string, error) {
+	fileName := path.Base(file)
+	a, err := fsAttachment(fsys, file, contentTypeByName(fileName))
+	if err != nil {
+		return "", err
+	}
+	return m.embed(fileName, a)
+}
+
+// EmbedBytes embeds data as file name like [message.Embed]. If contentType
+// is empty it is derived from the extension of name.
+//
+// Returns an error if a file with the same content ID is already embedded.
+func (m *message) EmbedBytes(name string, data []byte, contentType string) (string, error) {
+	if contentType == "" {
+		contentType = contentTypeByName(name)
+	}
+	return m.embed(name, bytesAttachment(data, contentType))
+}
+
+// embed appends a as inline part with file name and returns its cid: URL.
+//
+// Returns an error if a file with the same content ID is already embedded.
+func (m *message) embed(name string, a *Attachment) (string, error) {
+	contentID := ContentID(name)
+	for _, embedded := range m.attachments {
+		if embedded.contentID == contentID {
+			return "", fmt.Errorf("content ID %s of %s is already embedded", contentID, name)
+		}
+	}
+	a.filename = name
+	a.disposition = dispositionInline
+	a.contentID = contentID
+	m.attachments = append(m.attachments, a)
+	return "cid:" + a.contentID, nil
+}
+
+// SetTextBody sets the plain text body.
+//
+// If a HTML body is set too, both are sent as multipart/alternative.
+func (m *message) SetTextBody(text string) {
+	m.text = text
+}
+
+// TextBody returns the plain text body.
+func (m *message) TextBody() string {
+	return m.text
+}
+
+// SetHTMLBody sets the HTML body.
+//
+// If a plain text body is set too, both are sent as multipart/alternative.
+func (m *message) SetHTMLBody(html string) {
+	m.html = html
+}
+
+// HTMLBody returns the HTML body.
+func (m *message) HTMLBody() string {
+	return m.html
 }
 
 // SetNormalPriority sets the email priority to 'normal'.
@@ -133,52 +516,127 @@ func (m *message) Priority() string {
 	return m.priority
 }
 
-// FromString creates a new message with content from given string.
+// FromString creates a new message with plain text content from given string.
 func FromString(str string) *message {
 	m := new()
-	m.body = str
+	m.text = str
 	return m
 }
 
-// FromTemplateString creates a new message with content from parsed template string.
+// FromTemplateString creates a new message with HTML content from parsed template string.
+// The template may define the blocks "subject", "text" and "html",
+// see [FromAlternativeTemplateFiles].
 //
 // Returns an error if the template string could not be parsed.
 func FromTemplateString(data any, tpl string) (*message, error) {
-	buff := bytes.Buffer{}
-	template, _ := template.New("tinymail").Parse(tpl)
-	err := template.Execute(&buff, data)
+	template, err := template.New("tinymail").Parse(tpl)
 	if err != nil {
 		return nil, err
 	}
-	m := new()
-	m.body = buff.String()
-	return m, nil
+	return renderMessage(nil, template, "", data)
 }
 
-// FromTemplateFile creates a new message with content from parsed template file.
+// FromTemplateFile creates a new message with HTML content from parsed template file.
+// The template may define the blocks "subject", "text" and "html",
+// see [FromAlternativeTemplateFiles].
 //
 // Returns an error if the template file could not be parsed.
 func FromTemplateFile(data any, filenames ...string) (*message, error) {
-	buff := bytes.Buffer{}
-	template, _ := template.ParseFiles(filenames...)
-	err := template.Execute(&buff, data)
+	template, err := template.ParseFiles(filenames...)
 	if err != nil {
 		return nil, err
 	}
-	m := new()
-	m.body = buff.String()
-	return m, nil
+	return renderMessage(nil, template, "", data)
+}
+
+// FromTemplateFS creates a new message with HTML content from the template files
+// matching patterns in fsys, e.g. an [embed.FS], like [FromTemplateFile].
+//
+// Returns an error if a pattern matches no files or a template file could not be parsed.
+func FromTemplateFS(data any, fsys fs.FS, patterns ...string) (*message, error) {
+	template, err := template.ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return renderMessage(nil, template, "", data)
+}
+
+// FromTextTemplateString creates a new message with plain text content from parsed
+// template string. Unlike [FromTemplateString] the content is not HTML escaped.
+// The template may define the blocks "subject" and "text",
+// see [FromAlternativeTemplateFiles].
+//
+// Returns an error if the template string could not be parsed.
+func FromTextTemplateString(data any, tpl string) (*message, error) {
+	template, err := texttemplate.New("tinymail").Parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+	return renderMessage(template, nil, "", data)
+}
+
+// FromTextTemplateFile creates a new message with plain text content from parsed
+// template file. Unlike [FromTemplateFile] the content is not HTML escaped.
+// The template may define the blocks "subject" and "text",
+// see [FromAlternativeTemplateFiles].
+//
+// Returns an error if the template file could not be parsed.
+func FromTextTemplateFile(data any, filenames ...string) (*message, error) {
+	template, err := texttemplate.ParseFiles(filenames...)
+	if err != nil {
+		return nil, err
+	}
+	return renderMessage(template, nil, "", data)
+}
+
+// FromTextTemplateFS creates a new message with plain text content from the template
+// files matching patterns in fsys, e.g. an [embed.FS], like [FromTextTemplateFile].
+//
+// Returns an error if a pattern matches no files or a template file could not be parsed.
+func FromTextTemplateFS(data any, fsys fs.FS, patterns ...string) (*message, error) {
+	template, err := texttemplate.ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return renderMessage(template, nil, "", data)
+}
+
+// FromAlternativeTemplateFiles creates a new message with plain text and HTML content
+// from the template files name.txt and name.html, e.g. "templates/welcome" for
+// "templates/welcome.txt" and "templates/welcome.html". Both are executed with data
+// and sent as multipart/alternative.
+//
+// The templates may define blocks to set more than the body:
+//
+//	{{define "subject"}}Welcome {{.Name}}{{end}}
+//	{{define "text"}}plain text body{{end}}
+//	{{define "html"}}<p>HTML body</p>{{end}}
+//
+// Without "text" or "html" block the template itself is the body, "html" is only
+// used from the HTML template. Blocks of the plain text template take precedence.
+// Blocks other than "html" of the HTML template are unescaped after rendering,
+// but still parsed as HTML.
+//
+// Returns an error if one of the template files could not be parsed.
+func FromAlternativeTemplateFiles(data any, name string) (*message, error) {
+	text, err := texttemplate.ParseFiles(name + ".txt")
+	if err != nil {
+		return nil, err
+	}
+	html, err := template.ParseFiles(name + ".html")
+	if err != nil {
+		return nil, err
+	}
+	return renderMessage(text, html, "", data)
 }
 
 // new creates a new empty message.
 func new() *message {
 	return &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "",
-		attachments: map[string][]byte{},
+		attachments: []*Attachment{},
 	}
 }
@@ -1,8 +1,14 @@
 package tinymail
 
 import (
+	"net/mail"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,13 +31,12 @@ func TestFromString(t *testing.T) {
 	assert := assert.New(t)
 	msg := FromString("this is a test")
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "this is a test",
-		attachments: map[string][]byte{},
+		text:        "this is a test",
+		attachments: []*Attachment{},
 	}
 	assert.Equal(want, msg)
 }
@@ -41,13 +46,12 @@ func TestFromTemplateString(t *testing.T) {
 	msg, err := FromTemplateString(nil, string(tplString))
 	assert.NoError(err, "error creating msg from template string")
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        tplString,
-		attachments: map[string][]byte{},
+		html:        tplString,
+		attachments: []*Attachment{},
 	}
 	assert.Equal(want, msg)
 }
@@ -60,18 +64,111 @@ func TestFromTemplateFile(t *testing.T) {
 	msg, err := FromTemplateFile(nil, testFile)
 	assert.NoErrorf(err, "error creating msg from %s", testFile)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        tplString,
-		attachments: map[string][]byte{},
+		html:        tplString,
+		attachments: []*Attachment{},
 	}
 	assert.Equal(want, msg)
 	assert.NoErrorf(os.Remove(testFile), "error deleting %s", testFile)
 }
 
+func TestFromTextTemplateString(t *testing.T) {
+	assert := assert.New(t)
+	msg, err := FromTextTemplateString("O'Brien", "Hello {{.}} & <friends>")
+	assert.NoError(err)
+	assert.Equal("Hello O'Brien & <friends>", msg.TextBody())
+	assert.Empty(msg.HTMLBody())
+
+	_, err = FromTextTemplateString(nil, "{{.Name")
+	assert.Error(err)
+}
+
+func TestFromTextTemplateFile(t *testing.T) {
+	assert := assert.New(t)
+	testFile := filepath.Join(t.TempDir(), "test_template.txt")
+	assert.NoError(os.WriteFile(testFile, []byte("Hello {{.}}"), 0644))
+	msg, err := FromTextTemplateFile("O'Brien", testFile)
+	assert.NoError(err)
+	assert.Equal("Hello O'Brien", msg.TextBody())
+
+	_, err = FromTextTemplateFile(nil, "missing.txt")
+	assert.Error(err)
+}
+
+func TestFromAlternativeTemplateFiles(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "welcome.txt"), []byte("Hello {{.}}"), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "welcome.html"), []byte("<p>Hello {{.}}</p>"), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "text-only.txt"), []byte("Hello {{.}}"), 0644))
+
+	msg, err := FromAlternativeTemplateFiles("O'Brien", filepath.Join(dir, "welcome"))
+	assert.NoError(err)
+	assert.Equal("Hello O'Brien", msg.TextBody())
+	assert.Equal("<p>Hello O&#39;Brien</p>", msg.HTMLBody())
+
+	_, err = FromAlternativeTemplateFiles(nil, filepath.Join(dir, "text-only"))
+	assert.Error(err)
+}
+
+func TestFromTemplateStringBlocks(t *testing.T) {
+	assert := assert.New(t)
+	msg, err := FromTemplateString("O'Brien", `{{define "subject"}}Hello {{.}}{{end}}<p>Hello {{.}}</p>`)
+	assert.NoError(err)
+	assert.Equal("Hello O'Brien", msg.Subject())
+	assert.Equal("<p>Hello O&#39;Brien</p>", msg.HTMLBody())
+	assert.Empty(msg.TextBody())
+
+	msg, err = FromTextTemplateString("O'Brien", `{{define "subject"}}Hello {{.}}{{end}}
+{{define "text"}}Hello {{.}}{{end}}`)
+	assert.NoError(err)
+	assert.Equal("Hello O'Brien", msg.Subject())
+	assert.Equal("Hello O'Brien", msg.TextBody())
+}
+
+func TestFromTemplateFS(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"templates/welcome.html": {Data: []byte(`{{define "subject"}}Welcome {{.}}{{end}}<p>Welcome {{.}}</p>`)},
+		"templates/welcome.txt":  {Data: []byte(`Welcome {{.}} & more`)},
+	}
+
+	msg, err := FromTemplateFS("O'Brien", fsys, "templates/*.html")
+	assert.NoError(err)
+	assert.Equal("Welcome O'Brien", msg.Subject())
+	assert.Equal("<p>Welcome O&#39;Brien</p>", msg.HTMLBody())
+
+	msg, err = FromTextTemplateFS("O'Brien", fsys, "templates/*.txt")
+	assert.NoError(err)
+	assert.Equal("Welcome O'Brien & more", msg.TextBody())
+
+	_, err = FromTemplateFS(nil, fsys, "missing/*.html")
+	assert.Error(err)
+	_, err = FromTextTemplateFS(nil, fsys, "missing/*.txt")
+	assert.Error(err)
+}
+
+func TestFromTemplateParseError(t *testing.T) {
+	assert := assert.New(t)
+	msg, err := FromTemplateString(nil, "<p>{{.Name</p>")
+	assert.Error(err)
+	assert.Nil(msg)
+
+	testFile := "test_template_broken.html"
+	assert.NoError(os.WriteFile(testFile, []byte("<p>{{end}}</p>"), 0644))
+	defer os.Remove(testFile)
+	msg, err = FromTemplateFile(nil, testFile)
+	assert.Error(err)
+	assert.Nil(msg)
+
+	msg, err = FromTemplateFile(nil, "missing.html")
+	assert.Error(err)
+	assert.Nil(msg)
+}
+
 func TestAttach(t *testing.T) {
 	assert := assert.New(t)
 	fileName := "test_attach"
@@ -79,18 +176,15 @@ func TestAttach(t *testing.T) {
 	msg := FromString("TestAttach")
 	err := os.WriteFile(fileName, fileContent, 0644)
 	assert.NoErrorf(err, "error creating %s", fileName)
-	msg.Attach(fileName)
-	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
-		subject:     "",
-		body:        "TestAttach",
-		attachments: map[string][]byte{fileName: fileContent},
-	}
-	assert.Equal(want, msg)
+	assert.NoError(msg.Attach(fileName))
+	assert.Equal([]string{fileName + ": " + string(fileContent)}, readAttachments(t, msg.Attachments()...))
+	assert.Equal("application/octet-stream", msg.Attachments()[0].ContentType())
+	assert.Equal("attachment", msg.Attachments()[0].Disposition())
+	var attachments []*Attachment = msg.Attachments()
+	attachments[0] = nil
+	assert.NotNil(msg.Attachments()[0])
 	assert.NoError(os.Remove(fileName))
+	assert.Error(msg.Attach(fileName))
 }
 
 func TestAttachMultiple(t *testing.T) {
@@ -107,43 +201,77 @@ func TestAttachMultiple(t *testing.T) {
 	assert.NoError(os.WriteFile(nameFile2, contentFile2, 0644))
 	assert.NoError(os.WriteFile(nameFile3, contentFile3, 0644))
 
-	want := &message{
-		from:    "",
-		to:      []string{},
-		cc:      []string{},
-		bcc:     []string{},
-		subject: "",
-		body:    "TestAttachMultiple",
-		attachments: map[string][]byte{
-			nameFile1: contentFile1,
-			nameFile2: contentFile2,
-			nameFile3: contentFile3,
-		},
+	want := []string{
+		nameFile1 + ": " + string(contentFile1),
+		nameFile2 + ": " + string(contentFile2),
+		nameFile3 + ": " + string(contentFile3),
 	}
 
 	msg := FromString("TestAttachMultiple")
-	msg.Attach(nameFile1, nameFile2, nameFile3)
+	assert.NoError(msg.Attach(nameFile1, nameFile2, nameFile3))
 
-	assert.Equal(want, msg)
+	assert.Equal(want, readAttachments(t, msg.Attachments()...))
 
 	assert.NoError(os.Remove(nameFile1))
 	assert.NoError(os.Remove(nameFile2))
 	assert.NoError(os.Remove(nameFile3))
 }
 
+func TestAttachReader(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestAttachReader")
+	msg.AttachReader("invoice.pdf", strings.NewReader("invoice"), "")
+	msg.AttachReader("export", strings.NewReader("a,b"), "text/csv")
+
+	assert.Equal("application/pdf", msg.Attachments()[0].ContentType())
+	assert.Equal("text/csv", msg.Attachments()[1].ContentType())
+	assert.Equal([]string{"invoice.pdf: invoice", "export: a,b"}, readAttachments(t, msg.Attachments()...))
+
+	_, err := msg.Attachments()[0].open()
+	assert.Error(err)
+}
+
+func TestAttachBytes(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestAttachBytes")
+	msg.AttachBytes("invoice.pdf", []byte("invoice"), "")
+	msg.AttachBytes("export", []byte("a,b"), "text/csv")
+
+	assert.Equal("application/pdf", msg.Attachments()[0].ContentType())
+	assert.Equal("text/csv", msg.Attachments()[1].ContentType())
+	for i := 0; i < 2; i++ {
+		assert.Equal([]string{"invoice.pdf: invoice", "export: a,b"}, readAttachments(t, msg.Attachments()...))
+	}
+}
+
+func TestAttachFS(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"assets/terms.pdf": {Data: []byte("terms")},
+		"assets/dir/a.txt": {Data: []byte("a")},
+	}
+	msg := FromString("TestAttachFS")
+	assert.NoError(msg.AttachFS(fsys, "assets/terms.pdf"))
+	assert.Error(msg.AttachFS(fsys, "assets/missing.pdf"))
+	assert.Error(msg.AttachFS(fsys, "assets/dir"))
+
+	assert.Equal("application/pdf", msg.Attachments()[0].ContentType())
+	assert.Equal([]string{"terms.pdf: terms"}, readAttachments(t, msg.Attachments()...))
+}
+
 func TestSetFrom(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "test@testing.com",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		from:        &mail.Address{Address: "test@testing.com"},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "TestSetFrom",
-		attachments: map[string][]byte{},
+		text:        "TestSetFrom",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetFrom")
-	msg.SetFrom("test@testing.com")
+	assert.NoError(msg.SetFrom("test@testing.com"))
 	assert.Equal(want, msg)
 	assert.Equal(want.From(), msg.From())
 }
@@ -151,34 +279,32 @@ func TestSetFrom(t *testing.T) {
 func TestSetTo(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{"tester@testing.com"},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{{Address: "tester@testing.com"}},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "TestSetTo",
-		attachments: map[string][]byte{},
+		text:        "TestSetTo",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetTo")
-	msg.SetTo("tester@testing.com")
+	assert.NoError(msg.SetTo("tester@testing.com"))
 	assert.Equal(want, msg)
-	assert.Equal([]string{"tester@testing.com"}, msg.To())
+	assert.Equal([]*mail.Address{{Address: "tester@testing.com"}}, msg.To())
 	msg.SetTo("testerino@testing.com")
-	assert.Equal([]string{"testerino@testing.com"}, msg.To())
+	assert.Equal([]*mail.Address{{Address: "testerino@testing.com"}}, msg.To())
 	msg.SetTo("testerino@testing.com", "tester@testing.com")
-	assert.Equal([]string{"testerino@testing.com", "tester@testing.com"}, msg.To())
+	assert.Equal([]*mail.Address{{Address: "testerino@testing.com"}, {Address: "tester@testing.com"}}, msg.To())
 }
 
 func TestSetToMultiple(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{"tester1@testing.com", "tester2@testing.com"},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{{Address: "tester1@testing.com"}, {Address: "tester2@testing.com"}},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "TestSetToMultiple",
-		attachments: map[string][]byte{},
+		text:        "TestSetToMultiple",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetToMultiple")
 	msg.SetTo("tester1@testing.com", "tester2@testing.com")
@@ -189,13 +315,12 @@ func TestSetToMultiple(t *testing.T) {
 func TestSetCC(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{"tester@testing.com"},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{{Address: "tester@testing.com"}},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "TestSetCC",
-		attachments: map[string][]byte{},
+		text:        "TestSetCC",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetCC")
 	msg.SetCC("tester@testing.com")
@@ -205,13 +330,12 @@ func TestSetCC(t *testing.T) {
 func TestSetCCMultiple(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{"tester1@testing.com", "tester2@testing.com"},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{{Address: "tester1@testing.com"}, {Address: "tester2@testing.com"}},
+		bcc:         []*mail.Address{},
 		subject:     "",
-		body:        "TestSetCCMultiple",
-		attachments: map[string][]byte{},
+		text:        "TestSetCCMultiple",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetCCMultiple")
 	msg.SetCC("tester1@testing.com", "tester2@testing.com")
@@ -221,13 +345,12 @@ func TestSetCCMultiple(t *testing.T) {
 func TestSetBCC(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{"tester@testing.com"},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{{Address: "tester@testing.com"}},
 		subject:     "",
-		body:        "TestSetBCC",
-		attachments: map[string][]byte{},
+		text:        "TestSetBCC",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetBCC")
 	msg.SetBCC("tester@testing.com")
@@ -237,13 +360,12 @@ func TestSetBCC(t *testing.T) {
 func TestSetBCCMultiple(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{"tester1@testing.com", "tester2@testing.com"},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{{Address: "tester1@testing.com"}, {Address: "tester2@testing.com"}},
 		subject:     "",
-		body:        "TestSetBCCMultiple",
-		attachments: map[string][]byte{},
+		text:        "TestSetBCCMultiple",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetBCCMultiple")
 	msg.SetBCC("tester1@testing.com", "tester2@testing.com")
@@ -253,15 +375,277 @@ func TestSetBCCMultiple(t *testing.T) {
 func TestSetSubject(t *testing.T) {
 	assert := assert.New(t)
 	want := &message{
-		from:        "",
-		to:          []string{},
-		cc:          []string{},
-		bcc:         []string{},
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
 		subject:     "Test",
-		body:        "TestSetSubject",
-		attachments: map[string][]byte{},
+		text:        "TestSetSubject",
+		attachments: []*Attachment{},
 	}
 	msg := FromString("TestSetSubject")
 	msg.SetSubject("Test")
 	assert.Equal(want, msg)
 }
+
+func TestSetDisplayName(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetDisplayName")
+	assert.NoError(msg.SetFrom("Jane Doe <jane@testing.com>"))
+	assert.NoError(msg.SetTo(`"Doe, John" <john@testing.com>`, "Jürgen Müller <juergen@testing.com>"))
+	assert.Equal(&mail.Address{Name: "Jane Doe", Address: "jane@testing.com"}, msg.From())
+	assert.Equal([]*mail.Address{
+		{Name: "Doe, John", Address: "john@testing.com"},
+		{Name: "Jürgen Müller", Address: "juergen@testing.com"},
+	}, msg.To())
+}
+
+func TestSetInvalidAddress(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetInvalidAddress")
+	assert.NoError(msg.SetTo("tester@testing.com"))
+
+	assert.ErrorContains(msg.SetFrom("not an address"), `"not an address"`)
+	assert.Error(msg.SetTo("tester@testing.com", "Jane Doe <jane@"))
+	assert.Error(msg.SetCC("<missing-bracket@testing.com"))
+	assert.Error(msg.SetBCC(""))
+
+	assert.Nil(msg.From())
+	assert.Equal([]*mail.Address{{Address: "tester@testing.com"}}, msg.To())
+	assert.Empty(msg.CC())
+	assert.Empty(msg.BCC())
+}
+
+func TestSetTextAndHTMLBody(t *testing.T) {
+	assert := assert.New(t)
+	want := &message{
+		to:          []*mail.Address{},
+		cc:          []*mail.Address{},
+		bcc:         []*mail.Address{},
+		text:        "this is a test",
+		html:        "<p>this is a test</p>",
+		attachments: []*Attachment{},
+	}
+	msg := FromString("")
+	msg.SetTextBody("this is a test")
+	msg.SetHTMLBody("<p>this is a test</p>")
+	assert.Equal(want, msg)
+	assert.Equal("this is a test", msg.TextBody())
+	assert.Equal("<p>this is a test</p>", msg.HTMLBody())
+}
+
+func TestAttachContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	files := map[string]string{
+		"report.pdf":   "application/pdf",
+		"logo.PNG":     "image/png",
+		"export.csv":   "text/csv; charset=utf-8",
+		"letter.docx":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"unknown.blob": "application/octet-stream",
+	}
+	msg := FromString("TestAttachContentType")
+	for name, contentType := range files {
+		assert.NoError(os.WriteFile(name, []byte("content"), 0644))
+		defer os.Remove(name)
+		assert.NoError(msg.Attach(name))
+		assert.Equal(contentType, msg.Attachments()[len(msg.Attachments())-1].ContentType())
+	}
+
+	assert.NoError(msg.AttachWithType("export.csv", "application/vnd.ms-excel"))
+	assert.Equal("application/vnd.ms-excel", msg.Attachments()[len(msg.Attachments())-1].ContentType())
+	assert.Error(msg.AttachWithType("missing.csv", "text/csv"))
+}
+
+func TestEmbed(t *testing.T) {
+	assert := assert.New(t)
+	fileName := "test logo.png"
+	fileContent := []byte("png")
+	assert.NoError(os.WriteFile(fileName, fileContent, 0644))
+	defer os.Remove(fileName)
+
+	msg := FromString("TestEmbed")
+	cid, err := msg.Embed(fileName)
+	assert.NoError(err)
+	assert.Equal("cid:test_logo.png@tinymail", cid)
+	assert.Equal([]string{fileName + ": png"}, readAttachments(t, msg.Attachments()...))
+	assert.Equal("image/png", msg.Attachments()[0].ContentType())
+	assert.Equal("inline", msg.Attachments()[0].Disposition())
+	assert.Equal("test_logo.png@tinymail", msg.Attachments()[0].ContentID())
+
+	_, err = msg.Embed(fileName)
+	assert.Error(err)
+
+	_, err = msg.Embed("missing.png")
+	assert.Error(err)
+}
+
+func TestEmbedFS(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{"images/logo.png": {Data: []byte("png")}}
+
+	msg := FromString("TestEmbedFS")
+	cid, err := msg.EmbedFS(fsys, "images/logo.png")
+	assert.NoError(err)
+	assert.Equal("cid:logo.png@tinymail", cid)
+	assert.Equal([]string{"logo.png: png"}, readAttachments(t, msg.Attachments()...))
+	assert.Equal("image/png", msg.Attachments()[0].ContentType())
+	assert.Equal("inline", msg.Attachments()[0].Disposition())
+
+	_, err = msg.EmbedFS(fsys, "images/logo.png")
+	assert.Error(err)
+
+	_, err = msg.EmbedFS(fsys, "images/missing.png")
+	assert.Error(err)
+}
+
+func TestEmbedBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := FromString("TestEmbedBytes")
+	cid, err := msg.EmbedBytes("logo.png", []byte("png"), "")
+	assert.NoError(err)
+	assert.Equal("cid:logo.png@tinymail", cid)
+	cid, err = msg.EmbedBytes("chart", []byte("svg"), "image/svg+xml")
+	assert.NoError(err)
+	assert.Equal("cid:chart@tinymail", cid)
+	assert.Equal([]string{"logo.png: png", "chart: svg"}, readAttachments(t, msg.Attachments()...))
+	assert.Equal("image/png", msg.Attachments()[0].ContentType())
+	assert.Equal("image/svg+xml", msg.Attachments()[1].ContentType())
+	assert.Equal("inline", msg.Attachments()[1].Disposition())
+
+	_, err = msg.EmbedBytes("logo.png", []byte("png"), "")
+	assert.Error(err)
+}
+
+func TestAttachDuplicateNames(t *testing.T) {
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"a/report.pdf": {Data: []byte("a")},
+		"b/report.pdf": {Data: []byte("b")},
+	}
+	msg := FromString("TestAttachDuplicateNames")
+	assert.NoError(msg.AttachFS(fsys, "b/report.pdf"))
+	assert.NoError(msg.AttachFS(fsys, "a/report.pdf"))
+	msg.AttachBytes("report.pdf", []byte("c"), "")
+	assert.Equal([]string{"report.pdf: b", "report.pdf: a", "report.pdf: c"}, readAttachments(t, msg.Attachments()...))
+}
+
+func TestSetDate(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetDate")
+	assert.True(msg.Date().IsZero())
+	date := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	msg.SetDate(date)
+	assert.Equal(date, msg.Date())
+}
+
+func TestSetMessageID(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetMessageID")
+	assert.Empty(msg.MessageID())
+
+	assert.NoError(msg.SetMessageID("1234@testing.com"))
+	assert.Equal("<1234@testing.com>", msg.MessageID())
+	assert.NoError(msg.SetMessageID("<5678@testing.com>"))
+	assert.Equal("<5678@testing.com>", msg.MessageID())
+
+	assert.Error(msg.SetMessageID(""))
+	assert.Error(msg.SetMessageID("no-domain"))
+	assert.Error(msg.SetMessageID("two@at@testing.com"))
+	assert.Error(msg.SetMessageID("white space@testing.com"))
+	assert.Equal("<5678@testing.com>", msg.MessageID())
+}
+
+func TestSetHeader(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetHeader")
+	assert.Empty(msg.Header())
+
+	assert.NoError(msg.AddHeader("X-Tag", "first"))
+	assert.NoError(msg.AddHeader("x-tag", "second"))
+	assert.NoError(msg.SetHeader("X-Campaign-ID", "spring"))
+	assert.Equal(textproto.MIMEHeader{
+		"X-Tag":         {"first", "second"},
+		"X-Campaign-Id": {"spring"},
+	}, msg.Header())
+
+	assert.NoError(msg.SetHeader("X-Tag", "third"))
+	assert.Equal([]string{"third"}, msg.Header()["X-Tag"])
+
+	msg.Header().Set("From", "spoofed@testing.com")
+	assert.Empty(msg.Header().Get("From"))
+}
+
+func TestSetHeaderInvalid(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetHeaderInvalid")
+
+	assert.Error(msg.SetHeader("X-Tag", "value\r\nBcc: victim@testing.com"))
+	assert.Error(msg.AddHeader("X-Tag", "value\nX-Other: injected"))
+	assert.Error(msg.SetHeader("X-Tag: injected", "value"))
+	assert.Error(msg.SetHeader("", "value"))
+	assert.Error(msg.SetHeader("X", strings.Repeat("a", 1200)))
+	assert.Error(msg.AddHeader("X", strings.Repeat("a", 1200)))
+	for _, name := range []string{"Content-Type", "content-transfer-encoding", "MIME-Version", "From", "Bcc", "Message-ID"} {
+		assert.Error(msg.SetHeader(name, "value"), name)
+		assert.Error(msg.AddHeader(name, "value"), name)
+	}
+	assert.Empty(msg.Header())
+}
+
+func TestSetReplyTo(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetReplyTo")
+	assert.Empty(msg.ReplyTo())
+	assert.NoError(msg.SetReplyTo("Ticket <ticket@testing.com>", "support@testing.com"))
+	assert.Equal([]*mail.Address{
+		{Name: "Ticket", Address: "ticket@testing.com"},
+		{Address: "support@testing.com"},
+	}, msg.ReplyTo())
+	assert.Error(msg.SetReplyTo("support@testing.com", "invalid"))
+	assert.Len(msg.ReplyTo(), 2)
+}
+
+func TestSetSender(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetSender")
+	assert.Nil(msg.Sender())
+	assert.NoError(msg.SetSender("Relay <relay@testing.com>"))
+	assert.Equal(&mail.Address{Name: "Relay", Address: "relay@testing.com"}, msg.Sender())
+	assert.Error(msg.SetSender("invalid"))
+	assert.Equal("relay@testing.com", msg.Sender().Address)
+}
+
+func TestSetReturnPath(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetReturnPath")
+	assert.Nil(msg.ReturnPath())
+	assert.NoError(msg.SetReturnPath("bounces@testing.com"))
+	assert.Equal(&mail.Address{Address: "bounces@testing.com"}, msg.ReturnPath())
+	assert.Error(msg.SetReturnPath("invalid"))
+	assert.Equal("bounces@testing.com", msg.ReturnPath().Address)
+}
+
+func TestSetInReplyTo(t *testing.T) {
+	assert := assert.New(t)
+	msg := FromString("TestSetInReplyTo")
+	msg.SetSubject("Your ticket")
+	assert.Empty(msg.InReplyTo())
+	assert.Equal("Your ticket", msg.Subject())
+
+	assert.NoError(msg.SetInReplyTo("parent@testing.com"))
+	assert.Equal("<parent@testing.com>", msg.InReplyTo())
+	assert.Equal([]string{"<parent@testing.com>"}, msg.References())
+	assert.Equal("Re: Your ticket", msg.Subject())
+
+	assert.NoError(msg.SetInReplyTo("<parent@testing.com>", "<root@testing.com>", "parent@testing.com"))
+	assert.Equal([]string{"<root@testing.com>", "<parent@testing.com>"}, msg.References())
+	assert.Equal("Re: Your ticket", msg.Subject())
+
+	msg.SetSubject("RE: Your ticket")
+	assert.Equal("RE: Your ticket", msg.Subject())
+
+	assert.Error(msg.SetInReplyTo("invalid"))
+	assert.Error(msg.SetInReplyTo("<other@testing.com>", "invalid"))
+	assert.Equal("<parent@testing.com>", msg.InReplyTo())
+}
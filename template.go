@@ -0,0 +1,449 @@
+package tinymail
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// TemplateOpts configures the templates of [NewTemplates].
+type TemplateOpts struct {
+	// FS is the file system to read templates from, e.g. an [embed.FS].
+	// Patterns and Partials are matched in FS with [fs.Glob] if set,
+	// otherwise in the file system of the operating system.
+	FS fs.FS
+	// Patterns are glob patterns of template files, e.g. "templates/*".
+	// Files with extension .txt are plain text templates, all others HTML templates.
+	Patterns []string
+	// Partials are glob patterns of files parsed into every template,
+	// e.g. layouts, headers and footers. Files with extension .txt are
+	// available in plain text templates, all others in HTML templates.
+	Partials []string
+	// Layout is the name of a template defined in the partials executed
+	// as body instead of the template itself, e.g. "layout" for
+	//
+	//	{{define "layout"}}<html><body>{{block "content" .}}{{end}}</body></html>{{end}}
+	//
+	// with the templates defining "content".
+	Layout string
+	// Funcs are the functions available in all templates,
+	// e.g. for formatting dates and currencies.
+	Funcs map[string]any
+	// InlineCSS moves the rules of <style> elements of rendered HTML bodies
+	// into style attributes, see [InlineCSS].
+	InlineCSS bool
+}
+
+// Templates is a set of parsed templates to render messages from.
+//
+// Templates is safe for concurrent use.
+type Templates struct {
+	templates map[templateKey]*templateSet
+	fsys      fs.FS
+	layout    string
+	inlineCSS bool
+	text      *texttemplate.Template
+	html      *template.Template
+}
+
+// templateKey identifies the templates of a name and a normalized locale,
+// which is empty for templates without locale.
+type templateKey struct {
+	name   string
+	locale string
+}
+
+// templateSet holds the plain text and HTML template of a name, either may be nil.
+type templateSet struct {
+	text *texttemplate.Template
+	html *template.Template
+}
+
+// NewTemplates parses all files matching [TemplateOpts.Patterns] once.
+// Every file is a template named by its file name without extension,
+// e.g. "templates/welcome.html" is named "welcome". A plain text and
+// an HTML template with the same name are rendered as multipart/alternative.
+//
+// A BCP 47 language tag before the extension is the locale of the template,
+// e.g. "welcome.de-AT.html" is the template "welcome" for locale "de-AT",
+// see [Templates.RenderLocale]. Only tags with an ISO 639-1 language of two
+// letters are recognized, so "invoice.min.html" is the template "invoice.min",
+// but "report.no.html" is the template "report" for Norwegian.
+//
+// Returns an error if a pattern matches no files, a file could not be parsed,
+// two files of the same kind have the same name or a function is invalid.
+func NewTemplates(opts TemplateOpts) (*Templates, error) {
+	t := &Templates{
+		templates: map[templateKey]*templateSet{},
+		fsys:      opts.FS,
+		layout:    opts.Layout,
+		inlineCSS: opts.InlineCSS,
+	}
+	if err := t.setFuncs(opts.Funcs); err != nil {
+		return nil, err
+	}
+	partials, err := t.glob(opts.Partials)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range partials {
+		if err := t.parsePartial(file); err != nil {
+			return nil, err
+		}
+	}
+	files, err := t.glob(opts.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if err := t.parse(file); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// setFuncs creates the empty base templates with funcs.
+//
+// Returns an error if a name of funcs is no identifier or a value no suitable function.
+func (t *Templates) setFuncs(funcs map[string]any) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid template funcs: %v", r)
+		}
+	}()
+	t.text = texttemplate.New("").Funcs(texttemplate.FuncMap(funcs))
+	t.html = template.New("").Funcs(template.FuncMap(funcs))
+	return nil
+}
+
+// glob returns the files matching patterns in [TemplateOpts.FS]
+// or the file system of the operating system.
+//
+// Returns an error if a pattern is malformed or matches no files.
+func (t *Templates) glob(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		var matches []string
+		var err error
+		if t.fsys != nil {
+			matches, err = fs.Glob(t.fsys, pattern)
+		} else {
+			matches, err = filepath.Glob(pattern)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("template pattern %q matches no files", pattern)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// readFile reads file from [TemplateOpts.FS] or the file system of the operating system.
+func (t *Templates) readFile(file string) ([]byte, error) {
+	if t.fsys != nil {
+		return fs.ReadFile(t.fsys, file)
+	}
+	return os.ReadFile(file)
+}
+
+// parsePartial parses file into the plain text or HTML base template
+// depending on its extension.
+func (t *Templates) parsePartial(file string) error {
+	content, err := t.readFile(file)
+	if err != nil {
+		return err
+	}
+	if isTextTemplate(file) {
+		_, err = t.text.New(filepath.Base(file)).Parse(string(content))
+		return err
+	}
+	_, err = t.html.New(filepath.Base(file)).Parse(string(content))
+	return err
+}
+
+// parse parses file as plain text or HTML template depending on its extension
+// into a copy of the base template with the partials.
+func (t *Templates) parse(file string) error {
+	key := templateKeyOf(file)
+	set, ok := t.templates[key]
+	if !ok {
+		set = &templateSet{}
+		t.templates[key] = set
+	}
+	content, err := t.readFile(file)
+	if err != nil {
+		return err
+	}
+	if isTextTemplate(file) {
+		if set.text != nil {
+			return fmt.Errorf("text template %s of %s is already defined", key, file)
+		}
+		base, err := t.text.Clone()
+		if err != nil {
+			return err
+		}
+		set.text, err = base.New(filepath.Base(file)).Parse(string(content))
+		return err
+	}
+	if set.html != nil {
+		return fmt.Errorf("html template %s of %s is already defined", key, file)
+	}
+	base, err := t.html.Clone()
+	if err != nil {
+		return err
+	}
+	set.html, err = base.New(filepath.Base(file)).Parse(string(content))
+	return err
+}
+
+// templateKeyOf returns the name and locale of file, the file name without
+// extension split at the last dot if it is followed by a language tag.
+func templateKeyOf(file string) templateKey {
+	name := filepath.Base(file)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if i := strings.LastIndex(name, "."); i > 0 && isLocale(name[i+1:]) {
+		return templateKey{name: name[:i], locale: normalizeLocale(name[i+1:])}
+	}
+	return templateKey{name: name}
+}
+
+// String returns the name and the locale in parentheses if set.
+func (k templateKey) String() string {
+	if k.locale == "" {
+		return k.name
+	}
+	return k.name + " (" + k.locale + ")"
+}
+
+// isLocale reports whether s looks like a BCP 47 language tag, an ISO 639-1
+// language followed by subtags of up to 8 letters or digits.
+func isLocale(s string) bool {
+	subtags := strings.Split(normalizeLocale(s), "-")
+	if !languages[subtags[0]] {
+		return false
+	}
+	for _, subtag := range subtags[1:] {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, c := range subtag {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// languages are the ISO 639-1 language codes.
+var languages = func() map[string]bool {
+	codes := strings.Fields(`
+		aa ab ae af ak am an ar as av ay az ba be bg bh bi bm bn bo br bs ca ce ch
+		co cr cs cu cv cy da de dv dz ee el en eo es et eu fa ff fi fj fo fr fy ga
+		gd gl gn gu gv ha he hi ho hr ht hu hy hz ia id ie ig ii ik io is it iu ja
+		jv ka kg ki kj kk kl km kn ko kr ks ku kv kw ky la lb lg li ln lo lt lu lv
+		mg mh mi mk ml mn mr ms mt my na nb nd ne ng nl nn no nr nv ny oc oj om or
+		os pa pi pl ps pt qu rm rn ro ru rw sa sc sd se sg si sk sl sm sn so sq sr
+		ss st su sv sw ta te tg th ti tk tl tn to tr ts tt tw ty ug uk ur uz ve vi
+		vo wa wo xh yi yo za zh zu`)
+	languages := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		languages[code] = true
+	}
+	return languages
+}()
+
+// normalizeLocale returns locale in lower case with "-" as separator.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// localeFallbacks returns locale and its parents, e.g. "de-at", "de" and "".
+func localeFallbacks(locale string) []string {
+	locale = normalizeLocale(locale)
+	var fallbacks []string
+	for locale != "" {
+		fallbacks = append(fallbacks, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return append(fallbacks, "")
+}
+
+// isTextTemplate reports whether file is a plain text template.
+func isTextTemplate(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".txt")
+}
+
+// Render creates a new message from the templates name without locale
+// executed with data like [FromAlternativeTemplateFiles].
+//
+// Returns an error if there is no template name or it could not be executed.
+func (t *Templates) Render(name string, data any) (*message, error) {
+	return t.RenderLocale(name, "", data)
+}
+
+// RenderLocale creates a new message from the templates name for locale
+// executed with data like [Templates.Render].
+//
+// locale is a BCP 47 language tag like "de-AT" or "de_AT", e.g. the String of
+// a language.Tag. If there is no template for locale, the template of its
+// parents is used, e.g. "de-AT", "de" and finally the template without locale.
+// The plain text and the HTML template fall back independently, e.g.
+// "welcome.de.txt" and "welcome.html" are both used for "de".
+//
+// Returns an error if there is no template name for locale or its parents,
+// it could not be executed or its CSS could not be inlined.
+func (t *Templates) RenderLocale(name string, locale string, data any) (*message, error) {
+	var set templateSet
+	var found bool
+	for _, fallback := range localeFallbacks(locale) {
+		s, ok := t.templates[templateKey{name: name, locale: fallback}]
+		if !ok {
+			continue
+		}
+		found = true
+		if set.text == nil {
+			set.text = s.text
+		}
+		if set.html == nil {
+			set.html = s.html
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("template %s is not defined", templateKey{name: name, locale: normalizeLocale(locale)})
+	}
+	return t.render(&set, data)
+}
+
+// render creates a new message from set executed with data
+// and inlines the CSS of its HTML body if configured.
+func (t *Templates) render(set *templateSet, data any) (*message, error) {
+	m, err := renderMessage(set.text, set.html, t.layout, data)
+	if err != nil {
+		return nil, err
+	}
+	if t.inlineCSS && m.html != "" {
+		m.html, err = InlineCSS(m.html)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// renderer executes the blocks of a plain text or HTML template.
+type renderer struct {
+	// root is the name of the template itself.
+	root string
+	// layout is the name of the template executed instead of root, may be empty.
+	layout string
+	// defined are the names of all non-empty templates.
+	defined map[string]bool
+	execute func(w io.Writer, name string, data any) error
+	// escaped is set for HTML templates, their output is HTML escaped.
+	escaped bool
+}
+
+func textRenderer(tpl *texttemplate.Template, layout string) *renderer {
+	r := &renderer{root: tpl.Name(), layout: layout, defined: map[string]bool{}, execute: tpl.ExecuteTemplate}
+	for _, t := range tpl.Templates() {
+		r.define(t.Name(), t.Tree)
+	}
+	return r
+}
+
+func htmlRenderer(tpl *template.Template, layout string) *renderer {
+	r := &renderer{root: tpl.Name(), layout: layout, defined: map[string]bool{}, execute: tpl.ExecuteTemplate, escaped: true}
+	for _, t := range tpl.Templates() {
+		r.define(t.Name(), t.Tree)
+	}
+	return r
+}
+
+// define marks name as defined if tree is not empty.
+func (r *renderer) define(name string, tree *parse.Tree) {
+	if tree != nil && tree.Root != nil && !parse.IsEmptyTree(tree.Root) {
+		r.defined[name] = true
+	}
+}
+
+// render executes block with data or, if block is not defined and fallback is set,
+// the layout or the root template. HTML output is unescaped unless block is "html".
+//
+// Returns false if none of them is defined.
+func (r *renderer) render(block string, fallback bool, data any) (string, bool, error) {
+	name := block
+	switch {
+	case r.defined[block]:
+	case fallback && r.defined[r.layout]:
+		name = r.layout
+	case fallback && r.defined[r.root]:
+		name = r.root
+	default:
+		return "", false, nil
+	}
+	buff := bytes.Buffer{}
+	if err := r.execute(&buff, name, data); err != nil {
+		return "", false, err
+	}
+	if r.escaped && block != "html" {
+		return html.UnescapeString(buff.String()), true, nil
+	}
+	return buff.String(), true, nil
+}
+
+// renderMessage creates a new message from the plain text and HTML template
+// executed with data, either may be nil. See [FromAlternativeTemplateFiles]
+// for the blocks the templates may define and [TemplateOpts.Layout] for layout.
+func renderMessage(text *texttemplate.Template, html *template.Template, layout string, data any) (*message, error) {
+	var renderers []*renderer
+	if text != nil {
+		renderers = append(renderers, textRenderer(text, layout))
+	}
+	if html != nil {
+		renderers = append(renderers, htmlRenderer(html, layout))
+	}
+
+	m := new()
+	var subject string
+	var hasText, hasHTML, hasSubject bool
+	for _, r := range renderers {
+		var err error
+		if !hasText {
+			m.text, hasText, err = r.render("text", !r.escaped, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !hasHTML && r.escaped {
+			m.html, hasHTML, err = r.render("html", true, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !hasSubject {
+			subject, hasSubject, err = r.render("subject", false, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	m.subject = strings.TrimSpace(newlineReplacer.Replace(subject))
+	return m, nil
+}
@@ -0,0 +1,384 @@
+package tinymail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTemplates writes files to a temporary directory and returns it.
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestNewTemplates(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"welcome.html":       "<p>Welcome {{.}}</p>",
+		"reset.html":         "<p>Reset {{.}}</p>",
+		"invoice/paid.html":  "<p>Paid {{.}}</p>",
+		"invoice/ignore.txt": "ignored",
+	})
+
+	templates, err := NewTemplates(TemplateOpts{Patterns: []string{
+		filepath.Join(dir, "*.html"),
+		filepath.Join(dir, "invoice", "*.html"),
+	}})
+	test.NoError(err)
+
+	for name, want := range map[string]string{
+		"welcome": "<p>Welcome &lt;Jane&gt;</p>",
+		"reset":   "<p>Reset &lt;Jane&gt;</p>",
+		"paid":    "<p>Paid &lt;Jane&gt;</p>",
+	} {
+		msg, err := templates.Render(name, "<Jane>")
+		test.NoError(err)
+		test.Equal(want, msg.HTMLBody())
+	}
+
+	_, err = templates.Render("ignore", nil)
+	test.Error(err)
+}
+
+func TestNewTemplatesErrors(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"broken.html":    "<p>{{.Name</p>",
+		"a/welcome.html": "a",
+		"b/welcome.html": "b",
+	})
+
+	for _, patterns := range [][]string{
+		{filepath.Join(dir, "broken.html")},
+		{filepath.Join(dir, "missing", "*.html")},
+		{filepath.Join(dir, "[")},
+		{filepath.Join(dir, "a", "*.html"), filepath.Join(dir, "b", "*.html")},
+	} {
+		templates, err := NewTemplates(TemplateOpts{Patterns: patterns})
+		test.Error(err, patterns)
+		test.Nil(templates)
+	}
+}
+
+func TestTemplatesRenderError(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"welcome.html": "<p>{{.Name}}</p>",
+	})
+
+	templates, err := NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "*.html")}})
+	test.NoError(err)
+
+	_, err = templates.Render("welcome", 42)
+	test.Error(err)
+	_, err = templates.Render("missing", nil)
+	test.Error(err)
+}
+
+func TestTemplatesRenderConcurrent(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"welcome.html": "<p>Welcome {{.}}</p>",
+	})
+
+	templates, err := NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "*.html")}})
+	test.NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg, err := templates.Render("welcome", i)
+			test.NoError(err)
+			test.Equal(fmt.Sprintf("<p>Welcome %d</p>", i), msg.HTMLBody())
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestTemplatesRenderAlternative(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"welcome.txt":  "Welcome {{.}}, it's great",
+		"welcome.html": "<p>Welcome {{.}}, it's great</p>",
+		"notice.txt":   "Notice for {{.}}",
+	})
+
+	templates, err := NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "*")}})
+	test.NoError(err)
+
+	msg, err := templates.Render("welcome", "O'Brien")
+	test.NoError(err)
+	test.Equal("Welcome O'Brien, it's great", msg.TextBody())
+	test.Equal("<p>Welcome O&#39;Brien, it's great</p>", msg.HTMLBody())
+
+	msg, err = templates.Render("notice", "O'Brien")
+	test.NoError(err)
+	test.Equal("Notice for O'Brien", msg.TextBody())
+	test.Empty(msg.HTMLBody())
+
+	dir = writeTemplates(t, map[string]string{
+		"a/welcome.txt": "a",
+		"b/welcome.TXT": "b",
+	})
+	_, err = NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "*", "*")}})
+	test.Error(err)
+}
+
+func TestTemplatesRenderBlocks(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"welcome.html": `{{define "subject"}}
+  Welcome {{.Name}} & friends
+{{end}}
+{{define "headers"}}X-Campaign-ID: {{.Campaign}}{{end}}
+{{define "text"}}Hello {{.Name}}, it's great & more{{end}}
+{{define "html"}}<p>Hello {{.Name}}</p>{{end}}`,
+		"reset.txt":  `{{define "subject"}}Reset for {{.Name}}{{end}}Reset {{.Name}}`,
+		"reset.html": `{{define "subject"}}ignored{{end}}<p>Reset {{.Name}}</p>`,
+	})
+
+	templates, err := NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "*")}})
+	test.NoError(err)
+
+	data := map[string]string{"Name": "O'Brien", "Campaign": "spring"}
+	msg, err := templates.Render("welcome", data)
+	test.NoError(err)
+	test.Equal("Welcome O'Brien & friends", msg.Subject())
+	test.Equal("Hello O'Brien, it's great & more", msg.TextBody())
+	test.Equal("<p>Hello O&#39;Brien</p>", msg.HTMLBody())
+	test.Empty(msg.Header())
+
+	msg, err = templates.Render("reset", data)
+	test.NoError(err)
+	test.Equal("Reset for O'Brien", msg.Subject())
+	test.Equal("Reset O'Brien", msg.TextBody())
+	test.Equal("<p>Reset O&#39;Brien</p>", msg.HTMLBody())
+	test.Empty(msg.Header())
+}
+
+func TestTemplatesLayout(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"layouts/base.html": `{{define "layout"}}<html><body>{{template "header" .}}{{block "content" .}}{{end}}{{template "footer" .}}</body></html>{{end}}`,
+		"layouts/base.txt": `{{define "layout"}}{{block "content" .}}{{end}}
+--
+{{template "signature" .}}{{end}}`,
+		"partials/header.html":   `{{define "header"}}<h1>{{upper .Name}}</h1>{{end}}`,
+		"partials/footer.html":   `{{define "footer"}}<footer>{{price .Total}}</footer>{{end}}`,
+		"partials/signature.txt": `{{define "signature"}}Your {{upper "tinymail"}} team{{end}}`,
+		"mails/invoice.html":     `{{define "subject"}}Invoice for {{.Name}}{{end}}{{define "content"}}<p>Total {{price .Total}}</p>{{end}}`,
+		"mails/invoice.txt":      `{{define "content"}}Total {{price .Total}}{{end}}`,
+		"mails/no-layout.html":   `<p>{{upper .Name}}</p>`,
+	})
+
+	templates, err := NewTemplates(TemplateOpts{
+		Patterns: []string{filepath.Join(dir, "mails", "*")},
+		Partials: []string{filepath.Join(dir, "layouts", "*"), filepath.Join(dir, "partials", "*")},
+		Layout:   "layout",
+		Funcs: map[string]any{
+			"upper": strings.ToUpper,
+			"price": func(cents int) string { return fmt.Sprintf("%d.%02d EUR", cents/100, cents%100) },
+		},
+	})
+	test.NoError(err)
+
+	data := map[string]any{"Name": "Jane", "Total": 1299}
+	msg, err := templates.Render("invoice", data)
+	test.NoError(err)
+	test.Equal("Invoice for Jane", msg.Subject())
+	test.Equal("<html><body><h1>JANE</h1><p>Total 12.99 EUR</p><footer>12.99 EUR</footer></body></html>", msg.HTMLBody())
+	test.Equal("Total 12.99 EUR\n--\nYour TINYMAIL team", msg.TextBody())
+
+	msg, err = templates.Render("no-layout", data)
+	test.NoError(err)
+	test.Equal("<html><body><h1>JANE</h1><footer>12.99 EUR</footer></body></html>", msg.HTMLBody())
+}
+
+func TestTemplatesWithoutLayout(t *testing.T) {
+	test := assert.New(t)
+	dir := writeTemplates(t, map[string]string{
+		"partials/footer.html": `{{define "footer"}}<footer>{{.}}</footer>{{end}}`,
+		"mails/welcome.html":   `<p>Welcome</p>{{template "footer" .}}`,
+	})
+
+	templates, err := NewTemplates(TemplateOpts{
+		Patterns: []string{filepath.Join(dir, "mails", "*")},
+		Partials: []string{filepath.Join(dir, "partials", "*")},
+	})
+	test.NoError(err)
+
+	msg, err := templates.Render("welcome", "Jane")
+	test.NoError(err)
+	test.Equal("<p>Welcome</p><footer>Jane</footer>", msg.HTMLBody())
+
+	templates, err = NewTemplates(TemplateOpts{Patterns: []string{filepath.Join(dir, "mails", "*")}})
+	test.NoError(err)
+	_, err = templates.Render("welcome", "Jane")
+	test.Error(err)
+
+	for _, opts := range []TemplateOpts{
+		{Patterns: []string{filepath.Join(dir, "mails", "*")}, Partials: []string{filepath.Join(dir, "missing", "*")}},
+		{Patterns: []string{filepath.Join(dir, "mails", "*")}, Partials: []string{filepath.Join(dir, "partials", "*")}, Funcs: map[string]any{"invalid name": strings.ToUpper}},
+		{Patterns: []string{filepath.Join(dir, "mails", "*")}, Funcs: map[string]any{"upper": "no function"}},
+	} {
+		_, err := NewTemplates(opts)
+		test.Error(err)
+	}
+}
+
+func TestTemplatesFS(t *testing.T) {
+	test := assert.New(t)
+	fsys := fstest.MapFS{
+		"templates/layouts/base.html": {Data: []byte(`{{define "layout"}}<main>{{block "content" .}}{{end}}</main>{{end}}`)},
+		"templates/welcome.html":      {Data: []byte(`{{define "subject"}}Welcome {{.}}{{end}}{{define "content"}}<p>Welcome {{.}}</p>{{end}}`)},
+		"templates/welcome.txt":       {Data: []byte(`Welcome {{.}}`)},
+	}
+
+	templates, err := NewTemplates(TemplateOpts{
+		FS:       fsys,
+		Patterns: []string{"templates/*.*"},
+		Partials: []string{"templates/layouts/*.html"},
+		Layout:   "layout",
+	})
+	test.NoError(err)
+
+	msg, err := templates.Render("welcome", "Jane")
+	test.NoError(err)
+	test.Equal("Welcome Jane", msg.Subject())
+	test.Equal("Welcome Jane", msg.TextBody())
+	test.Equal("<main><p>Welcome Jane</p></main>", msg.HTMLBody())
+
+	for _, opts := range []TemplateOpts{
+		{FS: fsys, Patterns: []string{"missing/*"}},
+		{FS: fsys, Patterns: []string{"templates/[*"}},
+		{FS: fsys, Patterns: []string{"templates/*.html"}, Partials: []string{"missing/*"}},
+	} {
+		_, err := NewTemplates(opts)
+		test.Error(err)
+	}
+}
+
+func TestTemplatesRenderLocale(t *testing.T) {
+	test := assert.New(t)
+	fsys := fstest.MapFS{
+		"welcome.html":       {Data: []byte(`<p>Welcome {{.}}</p>`)},
+		"welcome.txt":        {Data: []byte(`Welcome {{.}}`)},
+		"welcome.de.html":    {Data: []byte(`<p>Willkommen {{.}}</p>`)},
+		"welcome.de-AT.html": {Data: []byte(`<p>Servus {{.}}</p>`)},
+		"welcome.es-419.txt": {Data: []byte(`Bienvenido {{.}}`)},
+		"notice.fr.html":     {Data: []byte(`<p>Avis</p>`)},
+		"invoice.v2.html":    {Data: []byte(`<p>Invoice</p>`)},
+		"invoice.min.html":   {Data: []byte(`<p>Minified</p>`)},
+		"report.new.txt":     {Data: []byte(`New report`)},
+		"report.new.de.txt":  {Data: []byte(`Neuer Bericht`)},
+		"report.new.html":    {Data: []byte(`<p>New report</p>`)},
+	}
+
+	templates, err := NewTemplates(TemplateOpts{FS: fsys, Patterns: []string{"*"}})
+	test.NoError(err)
+
+	for locale, want := range map[string]string{
+		"":           "<p>Welcome Jane</p>",
+		"en-US":      "<p>Welcome Jane</p>",
+		"de":         "<p>Willkommen Jane</p>",
+		"de-DE":      "<p>Willkommen Jane</p>",
+		"de-AT":      "<p>Servus Jane</p>",
+		"de_at":      "<p>Servus Jane</p>",
+		"DE-AT-1996": "<p>Servus Jane</p>",
+	} {
+		msg, err := templates.RenderLocale("welcome", locale, "Jane")
+		test.NoError(err, locale)
+		test.Equal(want, msg.HTMLBody(), locale)
+	}
+
+	msg, err := templates.RenderLocale("welcome", "es-419", "Jane")
+	test.NoError(err)
+	test.Equal("Bienvenido Jane", msg.TextBody())
+	test.Equal("<p>Welcome Jane</p>", msg.HTMLBody())
+
+	msg, err = templates.RenderLocale("welcome", "de", "Jane")
+	test.NoError(err)
+	test.Equal("Welcome Jane", msg.TextBody())
+	test.Equal("<p>Willkommen Jane</p>", msg.HTMLBody())
+
+	msg, err = templates.RenderLocale("report.new", "de", nil)
+	test.NoError(err)
+	test.Equal("Neuer Bericht", msg.TextBody())
+	test.Equal("<p>New report</p>", msg.HTMLBody())
+
+	msg, err = templates.Render("invoice.min", nil)
+	test.NoError(err)
+	test.Equal("<p>Minified</p>", msg.HTMLBody())
+
+	msg, err = templates.Render("invoice.v2", nil)
+	test.NoError(err)
+	test.Equal("<p>Invoice</p>", msg.HTMLBody())
+
+	_, err = templates.RenderLocale("notice", "fr-CA", nil)
+	test.NoError(err)
+	_, err = templates.RenderLocale("notice", "de", nil)
+	test.Error(err)
+	_, err = templates.Render("notice", nil)
+	test.Error(err)
+
+	_, err = NewTemplates(TemplateOpts{FS: fstest.MapFS{
+		"welcome.de-at.html": {Data: []byte("a")},
+		"welcome.de_AT.html": {Data: []byte("b")},
+	}, Patterns: []string{"*"}})
+	test.Error(err)
+}
+
+func TestLocaleFallbacks(t *testing.T) {
+	test := assert.New(t)
+
+	test.Equal([]string{""}, localeFallbacks(""))
+	test.Equal([]string{"de", ""}, localeFallbacks("de"))
+	test.Equal([]string{"zh-hant-tw", "zh-hant", "zh", ""}, localeFallbacks("zh_Hant_TW"))
+
+	for _, locale := range []string{"de", "de-AT", "es-419", "zh_Hant", "DE-at-1996"} {
+		test.True(isLocale(locale), locale)
+	}
+	for _, locale := range []string{"", "d", "deutsch", "v2", "de-", "de--at", "de-verylongsubtag", "de.at", "new", "min", "xx", "fil"} {
+		test.False(isLocale(locale), locale)
+	}
+}
+
+func TestTemplatesInlineCSS(t *testing.T) {
+	test := assert.New(t)
+	fsys := fstest.MapFS{
+		"welcome.html": {Data: []byte(`<style>p { color: {{.}} }</style><p>Welcome</p>`)},
+		"broken.html":  {Data: []byte(`<style>p { color: red</style><p>Welcome</p>`)},
+		"welcome.txt":  {Data: []byte(`<style>p { color: red }</style>`)},
+	}
+
+	templates, err := NewTemplates(TemplateOpts{FS: fsys, Patterns: []string{"*"}, InlineCSS: true})
+	test.NoError(err)
+
+	msg, err := templates.Render("welcome", "red")
+	test.NoError(err)
+	test.Equal(`<p style="color: red">Welcome</p>`, msg.HTMLBody())
+	test.Equal(`<style>p { color: red }</style>`, msg.TextBody())
+
+	_, err = templates.Render("broken", nil)
+	test.Error(err)
+
+	templates, err = NewTemplates(TemplateOpts{FS: fsys, Patterns: []string{"welcome.*"}})
+	test.NoError(err)
+	msg, err = templates.Render("welcome", "red")
+	test.NoError(err)
+	test.Equal(`<style>p { color: red }</style><p>Welcome</p>`, msg.HTMLBody())
+}